@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// The pack cache keeps the compressed blocks of every file that was packed, so that packing again only
+// compresses the files that changed. An entry is found by the hash of the uncompressed data, the codec
+// specification and the block size. The cache directory can be kept between runs, and it's safe to delete it.
+// Codecs that read a dictionary from a file are identified by the path, so the cache must be deleted when
+// such a dictionary changes.
+
+// packedBlock is one compressed block of a file, before it's written to the .ucas file
+type packedBlock struct {
+	method           string
+	uncompressedSize uint32
+	data             []byte
+}
+
+type packCache struct {
+	dir string
+}
+
+func openPackCache(dir string) (*packCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create the pack cache: %w", err)
+	}
+	return &packCache{dir: dir}, nil
+}
+
+func packCacheKey(hash FIoChunkHash, compression string, blockSize uint32) string {
+	hasher := sha1.New()
+	hasher.Write(hash.Hash[:])
+	binary.Write(hasher, binary.LittleEndian, blockSize)
+	hasher.Write([]byte(compression))
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+func (c *packCache) entryPath(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// load returns the cached blocks of a file of the given length; entries that can't be read are ignored.
+func (c *packCache) load(key string, length uint64) ([]packedBlock, bool) {
+	b, err := os.ReadFile(c.entryPath(key))
+	if err != nil {
+		return nil, false
+	}
+	r := bytes.NewReader(b)
+	var count uint32
+	if binary.Read(r, binary.LittleEndian, &count) != nil {
+		return nil, false
+	}
+	blocks := make([]packedBlock, 0, count)
+	total := uint64(0)
+	for i := uint32(0); i < count; i++ {
+		var hdr struct {
+			NameLength       uint8
+			UncompressedSize uint32
+			CompressedSize   uint32
+		}
+		if binary.Read(r, binary.LittleEndian, &hdr) != nil || int(hdr.NameLength)+int(hdr.CompressedSize) > r.Len() {
+			return nil, false
+		}
+		name := make([]byte, hdr.NameLength)
+		io.ReadFull(r, name)
+		data := make([]byte, hdr.CompressedSize)
+		io.ReadFull(r, data)
+		blocks = append(blocks, packedBlock{method: string(name), uncompressedSize: hdr.UncompressedSize, data: data})
+		total += uint64(hdr.UncompressedSize)
+	}
+	if total != length || r.Len() != 0 {
+		return nil, false
+	}
+	return blocks, true
+}
+
+// store writes the blocks of a file; the entry is renamed into place, so an interrupted run never leaves a partial entry.
+func (c *packCache) store(key string, blocks []packedBlock) error {
+	buf := bytes.NewBuffer([]byte{})
+	binary.Write(buf, binary.LittleEndian, uint32(len(blocks)))
+	for _, block := range blocks {
+		buf.WriteByte(uint8(len(block.method)))
+		binary.Write(buf, binary.LittleEndian, block.uncompressedSize)
+		binary.Write(buf, binary.LittleEndian, uint32(len(block.data)))
+		buf.WriteString(block.method)
+		buf.Write(block.data)
+	}
+	fpath := c.entryPath(key)
+	if err := os.MkdirAll(filepath.Dir(fpath), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fpath), key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), fpath)
+}
@@ -2,131 +2,522 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"compress/zlib"
 	"errors"
+	"fmt"
 	"io"
-	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
-	"github.com/new-world-tools/go-oodle"
+	"github.com/klauspost/compress/zstd"
 	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz/lzma"
 )
 
-// implemented (de)compression methods (lowercased)
+// A Codec compresses and decompresses the compression blocks of a container.
+// Codecs are created from a specification such as "zlib:9" or "oodle:leviathan:7", see NewCodec.
+type Codec interface {
+	// Name returns the name of the compression method as it is written in the .utoc file
+	Name() string
+	// Compress appends the compressed src to dst and returns the result
+	Compress(dst []byte, src []byte) ([]byte, error)
+	// Decompress decompresses src into dst, which has the exact size of the uncompressed data
+	Decompress(dst []byte, src []byte) error
+}
+
+// CodecOptions are the options of a codec specification; codecs return an error for options they don't support.
+type CodecOptions struct {
+	Level      int // only used if LevelSet is true
+	LevelSet   bool
+	Compressor string
+	Dictionary []byte
+}
+
+// CodecFactory creates a codec with the given options
+type CodecFactory func(options CodecOptions) (Codec, error)
+
+type codecRegistration struct {
+	name    string // as written in the .utoc file
+	factory CodecFactory
+}
 
+// implemented compression methods (see normalizeCompressionMethod for the keys)
 var (
-	DecompressionMethods = map[string](func(*[]byte, uint32) (*[]byte, error)){
-		"none":  decompressNone,
-		"zlib":  decompressZLIB,
-		"oodle": decompressOodle,
-		"lz4":   decompressLZ4,
-	}
-	CompressionMethods = map[string](func(*[]byte) (*[]byte, error)){
-		"none":  compressNone,
-		"zlib":  compressZLIB,
-		"oodle": compressOodle, // settings: level 3 Kraken compression
-		"lz4":   compressLZ4,
+	codecRegistry = map[string]codecRegistration{
+		"none":     {"None", newNoneCodec},
+		"zlib":     {"Zlib", newZlibCodec},
+		"gzip":     {"Gzip", newGzipCodec},
+		"oodle":    {"Oodle", newOodleCodec},
+		"lz4":      {"LZ4", newLZ4Codec},
+		"lz4frame": {"LZ4Frame", newLZ4FrameCodec}, // not used by the engine, kept for files packed with the frame format
+		"zstd":     {"Zstd", newZstdCodec},
+		"lzma":     {"LZMA", newLZMACodec},
+	}
+	// other names that games use for the same methods
+	compressionMethodAliases = map[string]string{
+		"zstandard": "zstd",
+		"gz":        "gzip",
 	}
+	codecRegistryLock sync.RWMutex
+
+	// codecs with the default options, used for decompression
+	defaultCodecs     = map[string]Codec{}
+	defaultCodecsLock sync.Mutex
 )
 
-/* Decompression functions */
-func decompressNone(inData *[]byte, expectedOutputSize uint32) (*[]byte, error) {
-	return inData, nil // can't go wrong :D
+// RegisterCodec registers a compression method, or replaces a built-in one.
+// The name is written in the .utoc file as given, but it's matched case-insensitively.
+func RegisterCodec(name string, factory CodecFactory) {
+	key := normalizeCompressionMethod(name)
+	codecRegistryLock.Lock()
+	codecRegistry[key] = codecRegistration{name, factory}
+	codecRegistryLock.Unlock()
+	defaultCodecsLock.Lock()
+	delete(defaultCodecs, key)
+	defaultCodecsLock.Unlock()
 }
 
-func decompressZLIB(inData *[]byte, expectedOutputSize uint32) (*[]byte, error) {
-	// decompress with zlib
-	r, err := zlib.NewReader(bytes.NewBuffer(*inData))
-	defer r.Close()
+// the key of a method in the registry; containers write the names with any capitalization,
+// sometimes with a separator ("LZ4", "Zstd", "ZSTD", "zstandard", "lz-4")
+func normalizeCompressionMethod(method string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == ' ' || r == '_' || r == '-' || r == '.' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(method)))
+	if alias, ok := compressionMethodAliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+// the supported methods, for error messages
+func supportedCompressionMethods() string {
+	codecRegistryLock.RLock()
+	defer codecRegistryLock.RUnlock()
+	var names []string
+	for key, reg := range codecRegistry {
+		if key != "lz4frame" {
+			names = append(names, reg.name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// NewCodec creates a codec from a specification: the compression method, followed by options that are
+// separated by colons. An option is either a number (the level), an Oodle compressor, or key=value with
+// the keys level, compressor and dict. The dict option takes the path of a dictionary file and must be
+// the last option, so that the path may contain colons.
+// Examples: "zlib", "zlib:9", "oodle:leviathan:7", "zstd:level=19:dict=C:\dicts\game.dict"
+func NewCodec(spec string) (Codec, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	key := normalizeCompressionMethod(parts[0])
+	codecRegistryLock.RLock()
+	reg, ok := codecRegistry[key]
+	codecRegistryLock.RUnlock()
+	if !ok {
+		return nil, errors.New("could not find compression method " + parts[0] + ". Please use one of " + supportedCompressionMethods())
+	}
+	var options CodecOptions
+	rest := ""
+	if len(parts) > 1 {
+		rest = parts[1]
+	}
+	for rest != "" {
+		var option string
+		if strings.HasPrefix(strings.ToLower(rest), "dict=") {
+			option, rest = rest, ""
+		} else if i := strings.IndexByte(rest, ':'); i >= 0 {
+			option, rest = rest[:i], rest[i+1:]
+		} else {
+			option, rest = rest, ""
+		}
+		if err := options.parse(option); err != nil {
+			return nil, fmt.Errorf("codec %s: %v", spec, err)
+		}
+	}
+	codec, err := reg.factory(options)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("codec %s: %v", spec, err)
+	}
+	return codec, nil
+}
+
+func (o *CodecOptions) parse(option string) error {
+	key, value := "", option
+	if i := strings.IndexByte(option, '='); i >= 0 {
+		key, value = strings.ToLower(strings.TrimSpace(option[:i])), option[i+1:]
+	}
+	if key == "" {
+		if _, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			key = "level"
+		} else {
+			key = "compressor"
+		}
+	}
+	switch key {
+	case "level":
+		level, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return errors.New("invalid level " + value)
+		}
+		o.Level = level
+		o.LevelSet = true
+	case "compressor":
+		o.Compressor = strings.ToLower(strings.TrimSpace(value))
+	case "dict":
+		dict, err := os.ReadFile(value)
+		if err != nil {
+			return err
+		}
+		o.Dictionary = dict
+	default:
+		return errors.New("unknown option " + key)
+	}
+	return nil
+}
+
+// returns an error for the options that a codec does not support
+func (o *CodecOptions) unsupported(level bool, compressor bool, dictionary bool) error {
+	if o.LevelSet && !level {
+		return errors.New("a compression level is not supported")
+	}
+	if o.Compressor != "" && !compressor {
+		return errors.New("unknown option " + o.Compressor)
 	}
-	uncompressed, err := ioutil.ReadAll(r)
+	if o.Dictionary != nil && !dictionary {
+		return errors.New("a dictionary is not supported")
+	}
+	return nil
+}
+
+func (o *CodecOptions) levelInRange(min int, max int) error {
+	if o.LevelSet && (o.Level < min || o.Level > max) {
+		return fmt.Errorf("the compression level must be between %d and %d", min, max)
+	}
+	return nil
+}
+
+// returns the codec of a compression method with the default options, which is cached
+func getCodec(method string) (Codec, error) {
+	key := normalizeCompressionMethod(method)
+	defaultCodecsLock.Lock()
+	defer defaultCodecsLock.Unlock()
+	if codec, ok := defaultCodecs[key]; ok {
+		return codec, nil
+	}
+	codec, err := NewCodec(method)
 	if err != nil {
 		return nil, err
 	}
-	if len(uncompressed) != int(expectedOutputSize) {
-		return nil, errors.New("zlib did not decompress correctly")
+	defaultCodecs[key] = codec
+	return codec, nil
+}
+
+// the name of the method that is written in the .utoc file; options of a codec specification are ignored
+func compressionMethodName(method string) string {
+	method = strings.SplitN(method, ":", 2)[0]
+	codecRegistryLock.RLock()
+	defer codecRegistryLock.RUnlock()
+	if reg, ok := codecRegistry[normalizeCompressionMethod(method)]; ok {
+		return reg.name
 	}
-	return &uncompressed, nil
+	return method
 }
 
-func decompressOodle(inData *[]byte, expectedOutputSize uint32) (*[]byte, error) {
-	if !oodle.IsDllExist() {
-		err := oodle.Download()
-		if err != nil {
-			return nil, errors.New("oo2core_9_win64.dll was not found (oodle decompression)")
-		}
+// reads all data of a decompressing reader into dst, which must be filled exactly
+func readExactly(r io.Reader, dst []byte, method string) error {
+	if _, err := io.ReadFull(r, dst); err != nil {
+		return errors.New(method + " did not decompress correctly: " + err.Error())
 	}
-	output, err := oodle.Decompress(*inData, int64(expectedOutputSize))
-	// if err is not nil, it's handled by the caller
-	return &output, err
+	var extra [1]byte
+	if n, _ := r.Read(extra[:]); n != 0 {
+		return errors.New(method + " did not decompress correctly")
+	}
+	return nil
+}
+
+/* None */
+
+type noneCodec struct{}
+
+func newNoneCodec(options CodecOptions) (Codec, error) {
+	return noneCodec{}, options.unsupported(false, false, false)
 }
-func decompressLZ4(inData *[]byte, expectedOutputSize uint32) (*[]byte, error) {
-	reader := bytes.NewReader(*inData)
-	decompressed := &bytes.Buffer{}
-	zr := lz4.NewReader(reader)
-	_, err := io.Copy(decompressed, zr)
+func (noneCodec) Name() string { return "None" }
+func (noneCodec) Compress(dst []byte, src []byte) ([]byte, error) {
+	return append(dst, src...), nil
+}
+func (noneCodec) Decompress(dst []byte, src []byte) error {
+	if len(src) != len(dst) {
+		return errors.New("uncompressed block has the wrong size")
+	}
+	copy(dst, src) // can't go wrong :D
+	return nil
+}
+
+/* Zlib and Gzip */
+
+type zlibCodec struct {
+	level      int
+	dictionary []byte
+}
+
+func newZlibCodec(options CodecOptions) (Codec, error) {
+	if err := options.unsupported(true, false, true); err != nil {
+		return nil, err
+	}
+	if err := options.levelInRange(-1, 9); err != nil {
+		return nil, err
+	}
+	c := &zlibCodec{level: zlib.DefaultCompression, dictionary: options.Dictionary}
+	if options.LevelSet {
+		c.level = options.Level
+	}
+	return c, nil
+}
+func (c *zlibCodec) Name() string { return "Zlib" }
+func (c *zlibCodec) Compress(dst []byte, src []byte) ([]byte, error) {
+	b := bytes.NewBuffer(dst)
+	w, err := zlib.NewWriterLevelDict(b, c.level, c.dictionary)
 	if err != nil {
 		return nil, err
 	}
-	decomp := decompressed.Bytes()
-	return &decomp, nil
+	if _, err = w.Write(src); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+func (c *zlibCodec) Decompress(dst []byte, src []byte) error {
+	r, err := zlib.NewReaderDict(bytes.NewReader(src), c.dictionary)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return readExactly(r, dst, "zlib")
 }
 
-/* Compression functions */
+type gzipCodec struct {
+	level int
+}
 
-func compressNone(inData *[]byte) (*[]byte, error) {
-	return inData, nil
+func newGzipCodec(options CodecOptions) (Codec, error) {
+	if err := options.unsupported(true, false, false); err != nil {
+		return nil, err
+	}
+	if err := options.levelInRange(-1, 9); err != nil {
+		return nil, err
+	}
+	c := &gzipCodec{level: gzip.DefaultCompression}
+	if options.LevelSet {
+		c.level = options.Level
+	}
+	return c, nil
 }
-func compressZLIB(inData *[]byte) (*[]byte, error) {
-	var b bytes.Buffer
-	w := zlib.NewWriter(&b)
-	_, err := w.Write(*inData)
+func (c *gzipCodec) Name() string { return "Gzip" }
+func (c *gzipCodec) Compress(dst []byte, src []byte) ([]byte, error) {
+	b := bytes.NewBuffer(dst)
+	w, err := gzip.NewWriterLevel(b, c.level)
 	if err != nil {
 		return nil, err
 	}
-	err = w.Close()
+	if _, err = w.Write(src); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+func (c *gzipCodec) Decompress(dst []byte, src []byte) error {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return readExactly(r, dst, "gzip")
+}
+
+/* LZ4 */
+
+// The engine stores raw LZ4 blocks, without the header of the LZ4 frame format.
+// Files that were packed with the frame format by earlier versions are still recognized by the magic number of the frame.
+type lz4Codec struct {
+	level int // 0 is the fast compressor, 1-9 the high compression compressor
+}
+
+func newLZ4Codec(options CodecOptions) (Codec, error) {
+	if err := options.unsupported(true, false, false); err != nil {
+		return nil, err
+	}
+	if err := options.levelInRange(0, 9); err != nil {
+		return nil, err
+	}
+	return &lz4Codec{level: options.Level}, nil
+}
+func (c *lz4Codec) Name() string { return "LZ4" }
+func (c *lz4Codec) Compress(dst []byte, src []byte) ([]byte, error) {
+	// with a buffer of this size, compression always succeeds
+	start := len(dst)
+	dst = append(dst, make([]byte, lz4.CompressBlockBound(len(src)))...)
+	var n int
+	var err error
+	if c.level == 0 {
+		var compressor lz4.Compressor
+		n, err = compressor.CompressBlock(src, dst[start:])
+	} else {
+		compressor := lz4.CompressorHC{Level: lz4.Level1 << uint(c.level-1)}
+		n, err = compressor.CompressBlock(src, dst[start:])
+	}
 	if err != nil {
 		return nil, err
 	}
-	compressedData := b.Bytes()
-	return &compressedData, nil
+	return dst[:start+n], nil
 }
-func compressOodle(inData *[]byte) (*[]byte, error) {
-	// The settings for Oodle _could_ be modified, but this is what Unreal Engine states as example
-	// https://docs.unrealengine.com/4.27/en-US/TestingAndOptimization/Oodle/Data/
-	compressedData, err := oodle.Compress(*inData, oodle.AlgoKraken, oodle.CompressionLevelOptimal3)
-	return &compressedData, err
+func (c *lz4Codec) Decompress(dst []byte, src []byte) error {
+	n, err := lz4.UncompressBlock(src, dst)
+	if err != nil && bytes.HasPrefix(src, []byte{0x04, 0x22, 0x4D, 0x18}) {
+		return lz4FrameCodec{}.Decompress(dst, src)
+	}
+	if err != nil {
+		return err
+	}
+	if n != len(dst) {
+		return errors.New("lz4 did not decompress correctly")
+	}
+	return nil
 }
 
-func compressLZ4(inData *[]byte) (*[]byte, error) {
-	reader := bytes.NewReader(*inData)
-	compressed := &bytes.Buffer{}
+type lz4FrameCodec struct{}
+
+func newLZ4FrameCodec(options CodecOptions) (Codec, error) {
+	return lz4FrameCodec{}, options.unsupported(false, false, false)
+}
+func (lz4FrameCodec) Name() string { return "LZ4Frame" }
+func (lz4FrameCodec) Compress(dst []byte, src []byte) ([]byte, error) {
+	compressed := bytes.NewBuffer(dst)
 	lzwriter := lz4.NewWriter(compressed)
-	_, err := io.Copy(lzwriter, reader)
-	if err != nil {
+	if _, err := lzwriter.Write(src); err != nil {
 		return nil, err
 	}
 	// Closing is *very* important
 	if err := lzwriter.Close(); err != nil {
 		return nil, err
 	}
-	comp := compressed.Bytes()
-	return &comp, nil
+	return compressed.Bytes(), nil
+}
+func (lz4FrameCodec) Decompress(dst []byte, src []byte) error {
+	return readExactly(lz4.NewReader(bytes.NewReader(src)), dst, "lz4")
 }
 
-/* Wrapper for getting the functions */
-// depending on the method, return the associated decompression function
-func getDecompressionFunction(method string) func(inData *[]byte, outputSize uint32) (*[]byte, error) {
-	if val, ok := DecompressionMethods[strings.ToLower(method)]; ok {
-		return val
+/* Zstandard */
+
+type zstdCodec struct {
+	options CodecOptions
+	lock    sync.Mutex
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func newZstdCodec(options CodecOptions) (Codec, error) {
+	if err := options.unsupported(true, false, true); err != nil {
+		return nil, err
 	}
-	return nil
+	if err := options.levelInRange(1, 22); err != nil {
+		return nil, err
+	}
+	return &zstdCodec{options: options}, nil
 }
-func getCompressionFunction(method string) func(inData *[]byte) (*[]byte, error) {
-	if val, ok := CompressionMethods[strings.ToLower(method)]; ok {
-		return val
+func (c *zstdCodec) Name() string { return "Zstd" }
+
+// dictionaries that were trained by zstd have a header; anything else is used as raw content
+func (c *zstdCodec) isRawDictionary() bool {
+	return !bytes.HasPrefix(c.options.Dictionary, []byte{0x37, 0xA4, 0x30, 0xEC})
+}
+
+func (c *zstdCodec) Compress(dst []byte, src []byte) ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.encoder == nil {
+		options := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+		if c.options.LevelSet {
+			options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.options.Level)))
+		}
+		if c.options.Dictionary != nil && c.isRawDictionary() {
+			options = append(options, zstd.WithEncoderDictRaw(0, c.options.Dictionary))
+		} else if c.options.Dictionary != nil {
+			options = append(options, zstd.WithEncoderDict(c.options.Dictionary))
+		}
+		encoder, err := zstd.NewWriter(nil, options...)
+		if err != nil {
+			return nil, err
+		}
+		c.encoder = encoder
+	}
+	return c.encoder.EncodeAll(src, dst), nil
+}
+func (c *zstdCodec) Decompress(dst []byte, src []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.decoder == nil {
+		options := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+		if c.options.Dictionary != nil && c.isRawDictionary() {
+			options = append(options, zstd.WithDecoderDictRaw(0, c.options.Dictionary))
+		} else if c.options.Dictionary != nil {
+			options = append(options, zstd.WithDecoderDicts(c.options.Dictionary))
+		}
+		decoder, err := zstd.NewReader(nil, options...)
+		if err != nil {
+			return err
+		}
+		c.decoder = decoder
+	}
+	out, err := c.decoder.DecodeAll(src, dst[:0])
+	if err != nil {
+		return err
+	}
+	if len(out) != len(dst) || (len(out) != 0 && &out[0] != &dst[0]) {
+		return errors.New("zstd did not decompress correctly")
 	}
 	return nil
 }
+
+/* LZMA */
+
+// LZMA data is stored in the .lzma format, which has a header with the properties and the size
+type lzmaCodec struct{}
+
+func newLZMACodec(options CodecOptions) (Codec, error) {
+	return lzmaCodec{}, options.unsupported(false, false, false)
+}
+func (lzmaCodec) Name() string { return "LZMA" }
+func (lzmaCodec) Compress(dst []byte, src []byte) ([]byte, error) {
+	b := bytes.NewBuffer(dst)
+	w, err := lzma.WriterConfig{Size: int64(len(src))}.NewWriter(b)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(src); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+func (lzmaCodec) Decompress(dst []byte, src []byte) error {
+	r, err := lzma.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return err
+	}
+	return readExactly(r, dst, "lzma")
+}
@@ -74,10 +74,28 @@ extern "C" {
 #endif
 
 extern __declspec(dllexport) int packGameFiles(char* dirPath, char* manifestPath, char* outFile, char* compressionMethod, char* AESKey);
+extern __declspec(dllexport) int packGameFilesWithOptions(char* dirPath, char* manifestPath, char* outFile, char* options);
+extern __declspec(dllexport) int packGameFilesDelta(char* dirPath, char* baseUtocFile, char* baseUcasFile, char* outFile, char* compressionMethod, char* AESKey);
+extern __declspec(dllexport) int packGameFilesDeltaWithOptions(char* dirPath, char* baseUtocFile, char* baseUcasFile, char* outFile, char* options);
 extern __declspec(dllexport) void freeStringList(char** stringlist, int n);
 extern __declspec(dllexport) char** listGameFiles(char* utocFile, int* n, char* AESKey);
+extern __declspec(dllexport) char** listGameChunks(char* utocFile, int* n, char* AESKey);
 extern __declspec(dllexport) char* getError();
 extern __declspec(dllexport) int createManifestFile(char* utocFile, char* ucasFile, char* outputFile, char* AESKey);
+extern __declspec(dllexport) int mergeManifests(char** manifestFiles, int n, char* outputFile, char* priority, char* reportFile);
+extern __declspec(dllexport) int subsetManifest(char** manifestFiles, int n, char* pathsFile, int withDependencies, char* outputFile);
+extern __declspec(dllexport) int validateManifests(char** manifestFiles, int n);
+extern __declspec(dllexport) int exportDependencyGraph(char* utocFile, char* ucasFile, char* outputFile, char* format, char* rootPackage, char* AESKey);
+extern __declspec(dllexport) char** listDependencies(char* utocFile, char* ucasFile, char* packageName, int reverse, int transitive, int* n, char* AESKey);
+extern __declspec(dllexport) char** listDependencyCycles(char* utocFile, char* ucasFile, int* n, char* AESKey);
+extern __declspec(dllexport) int diffGameFiles(char* oldUtocFile, char* oldUcasFile, char* newUtocFile, char* newUcasFile, char* outputFile, char* format, char* AESKey);
+extern __declspec(dllexport) int mergeGameFiles(char** utocFiles, char** ucasFiles, int n, char* outFile, char* priority, char* reportFile, char* AESKey);
+extern __declspec(dllexport) int searchGameFiles(char** utocFiles, char** ucasFiles, int n, char* query, char* outputFile, char* format, char* AESKey);
+extern __declspec(dllexport) int editGameFiles(char* utocFile, char* ucasFile, char* outFile, char* editsFile, char* compressionMethod, char* AESKey);
+extern __declspec(dllexport) int transcodeGameFiles(char* utocFile, char* ucasFile, char* outFile, char* compressionMethod, int blockSize, int blockAlignment, char* AESKey);
+extern __declspec(dllexport) int setOodleLibraryPath(char* libPath);
+extern __declspec(dllexport) int verifyGameFiles(char* utocFile, char* ucasFile, char* publicKey, char* AESKey);
+extern __declspec(dllexport) int unpackGameChunk(char* utocFile, char* ucasFile, char* chunkID, char* outputFile, char* AESKey);
 extern __declspec(dllexport) int unpackAllGameFiles(char* utocFile, char* ucasFile, char* outputDirectory, char* AESKey);
 extern __declspec(dllexport) int unpackGameFiles(char* utocFile, char* ucasFile, char* outputDirectory, char* regex, char* AESKey);
 
@@ -1,4 +1,6 @@
 # Python script to fix the paths in the manifest file generated by UECASTOC so that UECASTOC can pack the files.
+# The packer can do this by itself now: "main.exe --discover --subset pack ..." finds the files of the manifest in the mod folder
+# and packs the ones that are present, without changing the manifest. This script is kept for older versions.
 # This script should be installed and run from the "cpp" directory of a UECASTOC ( https://github.com/gitMenv/UEcastoc/tags ) installation
 
 # Caveats:
@@ -9,20 +9,57 @@ using namespace std;
 
 // Print help text on usage
 void printHelp() {
-    cout << "Usage: castoc.exe <feature> [args]" << endl;
+    cout << "Usage: castoc.exe [--oodle libraryPath] [--dedup] [--cache cacheDir] [--mount mountPoint] [--sign keyPath] [--keyguid guid] [--discover] [--subset] <feature> [args]" << endl;
     cout << "All args that are prepended with an asterisk are optional" << endl;
     cout << "Features:" << endl;
     cout << "  help: Print this message" << endl;
     cout << "  list [utocPath, *AES key]: lists all files that are packed in the .utoc/.ucas file" << endl;
+    cout << "  chunks [utocPath, *AES key]: lists all chunks by chunk ID and type, including those without a path" << endl;
+    cout << "  unpackChunk [utocPath, ucasPath, chunkID, outputFile, *AES key]: unpack a single chunk by its chunk ID" << endl;
+    cout << "  verify [utocPath, ucasPath, *publicKeyPath, *AES key]: checks the block hashes and signatures of a signed .utoc/.ucas file" << endl;
     cout << "  unpackAll [utocPath, ucasPath, outputDir, *AES key]: unpack entire .utoc/.ucas files" << endl;
     cout << "  unpack [utocPath, ucasPath, outputDir, regex, *AES key]: unpack .utoc/.ucas files based on regex" << endl;
     cout << "  manifest [utocPath, ucasPath, outputManifest, *AES key]: creates Manifest file of this .utoc/.ucas file" << endl; 
-    cout << "  pack [packDir, manifestPath, outputFile, compressionMethod, *AES key]: pack directory into .utoc/.ucas file" << endl;
+    cout << "  mergeManifests [outputManifest, priority, reportFile, manifestPath, manifestPath, ...]: combine the manifests of several containers into one; priority is first or last" << endl;
+    cout << "  subsetManifest [outputManifest, pathsFile, withDependencies, manifestPath, ...]: a manifest with the files in pathsFile, one per line, and the packages they depend on if withDependencies is 1" << endl;
+    cout << "  validateManifests [manifestPath, ...]: checks that every package that a package depends on is in the manifests" << endl;
+    cout << "  pack [packDir, manifestPath, outputFile, compressionMethod, *blockSize, *packOrder, *fileAlignment, *AES key]: pack directory into .utoc/.ucas file" << endl;
+    cout << "  packDelta [packDir, baseUtocPath, baseUcasPath, outputFile, compressionMethod, *blockSize, *packOrder, *fileAlignment, *AES key]: pack only the files that differ from the base .utoc/.ucas file" << endl;
+    cout << "  diff [oldUtocPath, oldUcasPath, newUtocPath, newUcasPath, outputFile, *format, *AES key]: lists the changes between two .utoc/.ucas files as text or json" << endl;
+    cout << "  graph [utocPath, ucasPath, outputFile, *format, *package, *AES key]: writes the dependencies of the packages as dot, graphml or json; with a package, only the packages it needs and the packages that need it" << endl;
+    cout << "  deps [utocPath, ucasPath, package, *mode, *AES key]: lists the packages that the package imports; the mode is one of {imports, all, dependents, allDependents}" << endl;
+    cout << "  cycles [utocPath, ucasPath, *AES key]: lists the groups of packages that depend on each other" << endl;
+    cout << "  search [outputFile, query, format, utocPath, ucasPath, utocPath, ucasPath, ...]: finds chunks by chunk ID, package ID, hash, type, size, name or path; format is json or text" << endl;
+    cout << "  merge [outputFile, priority, reportFile, utocPath, ucasPath, utocPath, ucasPath, ...]: merge several .utoc/.ucas files into one; priority is first or last" << endl;
+    cout << "  edit [utocPath, ucasPath, outputFile, editsFile, *compressionMethod]: apply the edits in the JSON edits file to a .utoc/.ucas file" << endl;
+    cout << "  transcode [utocPath, ucasPath, outputFile, compressionMethod, *blockSize, *blockAlignment, *AES key]: write a .utoc/.ucas file with a different compression" << endl;
     cout << endl;
     cout << "the pack command requires the manifest file, and it packs the input dir to the outputFile{.utoc, .ucas, .pak}; three files are created!" << endl;
-    cout << "the following compression methods for packing are supported; {None, Zlib, Oodle}" << endl;
+    cout << "the following compression methods for packing are supported; {None, Zlib, Gzip, Oodle, LZ4, Zstd, LZMA}" << endl;
+    cout << "the compression method \"original\" compresses every file like the container that the manifest was created from" << endl;
+    cout << "options may follow the compression method, such as the level or the Oodle compressor; e.g. zlib:9 or oodle:leviathan:7" << endl;
+    cout << "rules for specific files may follow, separated by semicolons; e.g. \"oodle;*.bnk=none;type:BulkData=zlib\"" << endl;
+    cout << "the pack order is one of {manifest, container, path, type, dependencies} or the path of an order file such as GameOpenOrder.log" << endl;
+    cout << "--oodle loads the Oodle library from the given path; without it, Oodle files are decompressed without the library, but packing with Oodle is not possible" << endl;
+    cout << "--dedup stores files with the same content only once when packing" << endl;
+    cout << "--cache keeps the compressed files in the given directory, so packing again only compresses the files that changed" << endl;
+    cout << "--sign signs the packed container with the RSA private key in the given PEM file" << endl;
+    cout << "--keyguid sets the GUID of the AES key that encrypts the packed container, which is also used to find the key in a keyring" << endl;
+    cout << "every *AES key is a key in hexadecimal format or base64, or the path of a keyring file" << endl;
+    cout << "--discover searches the files that are not at their path in packDir by the end of their path, e.g. for the layout of .pak mods" << endl;
+    cout << "--subset packs only the files of the manifest that are found, instead of failing on missing files" << endl;
+    cout << "--mount sets the mount point of the packed container, e.g. ../../../Game/Content/; by default it is taken from the manifest" << endl;
 }
 
+// set by the --dedup, --cache, --mount, --sign, --keyguid, --discover and --subset options
+int deduplicate = 0;
+int discover = 0;
+int subset = 0;
+char* cacheDir = NULL;
+char* mountPoint = NULL;
+char* signingKey = NULL;
+char* keyGuid = NULL;
+
 void help(vector<string> args) {
     printHelp();
 }
@@ -53,6 +90,78 @@ void list(vector<string> args) {
     freeStringList(list, n);
 }
 
+void chunks(vector<string> args) {
+    // [utocPath, *AES key]
+    if (args.size() == 0){
+        cout << "expecting at least one arg for chunks" << endl;
+        printHelp();
+        return;
+    }
+    int n;
+    char* aeskey = NULL;
+    if (args.size() > 1){
+        aeskey = const_cast<char*>(args[1].c_str());
+    }
+    char** list = listGameChunks(const_cast<char*>(args[0].c_str()), &n, aeskey);
+    if(list == NULL){
+        cout << getError() << endl;
+        return;
+    }
+    for(int i = 0; i < n; i++){
+        cout << list[i] << endl;
+    }
+    freeStringList(list, n);
+}
+
+void unpackChunk(vector<string> args){
+    // [utocPath, ucasPath, chunkID, outputFile, *AES key]
+    if (args.size() < 4){
+        cout << "expecting at least four args for unpackChunk" << endl;
+        printHelp();
+        return;
+    }
+    char* aeskey = NULL;
+    if (args.size() > 4){
+        aeskey = const_cast<char*>(args[4].c_str());
+    }
+    int err = unpackGameChunk(const_cast<char*>(args[0].c_str()),
+        const_cast<char*>(args[1].c_str()),
+        const_cast<char*>(args[2].c_str()),
+        const_cast<char*>(args[3].c_str()),
+        aeskey);
+    if(err < 0){
+        cout << getError() << endl;
+    }else{
+        cout << "unpacked chunk " << args[2] << endl;
+    }
+}
+
+void verify(vector<string> args){
+    // [utocPath, ucasPath, *publicKeyPath, *AES key]
+    if (args.size() < 2){
+        cout << "expecting at least two args for verify" << endl;
+        printHelp();
+        return;
+    }
+    char* publicKey = NULL;
+    char* aeskey = NULL;
+    if (args.size() > 2){
+        publicKey = const_cast<char*>(args[2].c_str());
+    }
+    if (args.size() > 3){
+        aeskey = const_cast<char*>(args[3].c_str());
+    }
+    int err = verifyGameFiles(const_cast<char*>(args[0].c_str()),
+        const_cast<char*>(args[1].c_str()),
+        publicKey,
+        aeskey);
+    if(err < 0){
+        cout << getError() << endl;
+    }else{
+        cout << "the container is valid" << endl;
+    }
+}
+
 void unpackAll(vector<string> args){
     //[utocPath, ucasPath, outputDir, *AES key]
     if (args.size() < 3){
@@ -134,33 +243,394 @@ void manifest(vector<string> args){
 
 }
 
+void mergeManifestFiles(vector<string> args){
+    // [outputManifest, priority, reportFile, manifestPath, manifestPath, ...]
+    if(args.size() < 4){
+        cout << "expecting an output manifest, priority, report file and at least one manifest for merging" << endl;
+        printHelp();
+        return;
+    }
+    vector<char*> manifests;
+    for(size_t i = 3; i < args.size(); i++){
+        manifests.push_back(const_cast<char*>(args[i].c_str()));
+    }
+    int n = mergeManifests(manifests.data(), (int)manifests.size(),
+        const_cast<char*>(args[0].c_str()),
+        const_cast<char*>(args[1].c_str()),
+        const_cast<char*>(args[2].c_str()));
+    if(n < 0){
+        cout << getError() << endl;
+    }else{
+        cout << "number of files in manifest:" << n << endl;
+    }
+}
+
+void subsetManifestFile(vector<string> args){
+    // [outputManifest, pathsFile, withDependencies, manifestPath, ...]
+    if(args.size() < 4){
+        cout << "expecting an output manifest, paths file, withDependencies and at least one manifest" << endl;
+        printHelp();
+        return;
+    }
+    vector<char*> manifests;
+    for(size_t i = 3; i < args.size(); i++){
+        manifests.push_back(const_cast<char*>(args[i].c_str()));
+    }
+    int n = subsetManifest(manifests.data(), (int)manifests.size(),
+        const_cast<char*>(args[1].c_str()),
+        (int)stoul(args[2], nullptr, 0),
+        const_cast<char*>(args[0].c_str()));
+    if(n < 0){
+        cout << getError() << endl;
+    }else{
+        cout << "number of files in manifest:" << n << endl;
+    }
+}
+
+void validateManifestFiles(vector<string> args){
+    // [manifestPath, ...]
+    if(args.size() < 1){
+        cout << "expecting at least one manifest to validate" << endl;
+        printHelp();
+        return;
+    }
+    vector<char*> manifests;
+    for(size_t i = 0; i < args.size(); i++){
+        manifests.push_back(const_cast<char*>(args[i].c_str()));
+    }
+    if(validateManifests(manifests.data(), (int)manifests.size()) < 0){
+        cout << getError() << endl;
+    }else{
+        cout << "all dependencies were found" << endl;
+    }
+}
+
+// quotes a string for a JSON document, such as the pack options
+string jsonString(const string& s) {
+    string quoted = "\"";
+    for (char c : s) {
+        if (c == '"' || c == '\\') {
+            quoted += '\\';
+        }
+        quoted += c;
+    }
+    return quoted + "\"";
+}
+
+// the pack options as JSON for packGameFilesWithOptions, from the optional args and the --options
+string packOptions(const string& compression, const string& blockSize, const string& packOrder, const string& fileAlignment, const string& aesKey, bool delta) {
+    string options = "{\"Compression\": " + jsonString(compression);
+    if (!blockSize.empty()) {
+        options += ", \"BlockSize\": " + to_string(stoul(blockSize, nullptr, 0));
+    }
+    if (!packOrder.empty()) {
+        options += ", \"Order\": " + jsonString(packOrder);
+    }
+    if (!fileAlignment.empty()) {
+        options += ", \"FileAlignment\": " + to_string(stoul(fileAlignment, nullptr, 0));
+    }
+    if (deduplicate) {
+        options += ", \"Deduplicate\": true";
+    }
+    if (cacheDir != NULL) {
+        options += ", \"CacheDir\": " + jsonString(cacheDir);
+    }
+    if (mountPoint != NULL) {
+        options += ", \"MountPoint\": " + jsonString(mountPoint);
+    }
+    if (signingKey != NULL) {
+        options += ", \"SigningKey\": " + jsonString(signingKey);
+    }
+    if (!delta && keyGuid != NULL) {
+        options += ", \"AESKeyGuid\": " + jsonString(keyGuid);
+    }
+    if (!delta && discover) {
+        options += ", \"Discover\": true";
+    }
+    if (!delta && subset) {
+        options += ", \"Subset\": true";
+    }
+    if (!aesKey.empty()) {
+        options += ", \"AESKey\": " + jsonString(aesKey);
+    }
+    return options + "}";
+}
+
 void pack(vector<string> args){
-    // [packDir, manifestPath, outputFile, compressionMethod, *AES key]
+    // [packDir, manifestPath, outputFile, compressionMethod, *blockSize, *packOrder, *fileAlignment, *AES key]
     if(args.size() < 4){
         cout << "expecting at least 4 arguments for packing" << endl;
         printHelp();
         return;
     }
-    const char* packdir = args[0].c_str();
-    const char* manifestPath = args[1].c_str();
-    const char* outputfile = args[2].c_str();
-    const char* compression = args[3].c_str();
-    char* aeskey;
-    if(args.size() == 5){
-        const char* aes = args[4].c_str();
-        aeskey = const_cast<char*>(aes);
+    args.resize(8);
+    string options = packOptions(args[3], args[4], args[5], args[6], args[7], false);
+    int n = packGameFilesWithOptions(const_cast<char*>(args[0].c_str()),
+        const_cast<char*>(args[1].c_str()),
+        const_cast<char*>(args[2].c_str()),
+        const_cast<char*>(options.c_str()));
+    if(n < 0){
+        cout << getError() << endl;
+    }else{
+        cout << "number of files packed:" << n << endl;
     }
-    int n = packGameFiles(const_cast<char*>(packdir),
-        const_cast<char*>(manifestPath),
-        const_cast<char*>(outputfile),
-        const_cast<char*>(compression),
-        aeskey);
+
+}
+
+void packDelta(vector<string> args){
+    // [packDir, baseUtocPath, baseUcasPath, outputFile, compressionMethod, *blockSize, *packOrder, *fileAlignment, *AES key]
+    if(args.size() < 5){
+        cout << "expecting at least 5 arguments for delta packing" << endl;
+        printHelp();
+        return;
+    }
+    args.resize(9);
+    string options = packOptions(args[4], args[5], args[6], args[7], args[8], true);
+    int n = packGameFilesDeltaWithOptions(const_cast<char*>(args[0].c_str()),
+        const_cast<char*>(args[1].c_str()),
+        const_cast<char*>(args[2].c_str()),
+        const_cast<char*>(args[3].c_str()),
+        const_cast<char*>(options.c_str()));
     if(n < 0){
         cout << getError() << endl;
     }else{
         cout << "number of files packed:" << n << endl;
     }
+}
+
+void diff(vector<string> args){
+    // [oldUtocPath, oldUcasPath, newUtocPath, newUcasPath, outputFile, *format, *AES key]
+    if(args.size() < 5){
+        cout << "expecting at least 5 arguments for diffing" << endl;
+        printHelp();
+        return;
+    }
+    char* format = NULL;
+    char* aeskey = NULL;
+    if(args.size() > 5){
+        format = const_cast<char*>(args[5].c_str());
+    }
+    if(args.size() > 6){
+        aeskey = const_cast<char*>(args[6].c_str());
+    }
+    int n = diffGameFiles(const_cast<char*>(args[0].c_str()),
+        const_cast<char*>(args[1].c_str()),
+        const_cast<char*>(args[2].c_str()),
+        const_cast<char*>(args[3].c_str()),
+        const_cast<char*>(args[4].c_str()),
+        format,
+        aeskey);
+    if(n < 0){
+        cout << getError() << endl;
+    }else{
+        cout << "number of changed files:" << n << endl;
+    }
+}
+
+void graph(vector<string> args){
+    // [utocPath, ucasPath, outputFile, *format, *package, *AES key]
+    if(args.size() < 3){
+        cout << "expecting at least 3 arguments for graph" << endl;
+        printHelp();
+        return;
+    }
+    char* format = NULL;
+    char* package = NULL;
+    char* aeskey = NULL;
+    if(args.size() > 3){
+        format = const_cast<char*>(args[3].c_str());
+    }
+    if(args.size() > 4){
+        package = const_cast<char*>(args[4].c_str());
+    }
+    if(args.size() > 5){
+        aeskey = const_cast<char*>(args[5].c_str());
+    }
+    int n = exportDependencyGraph(const_cast<char*>(args[0].c_str()),
+        const_cast<char*>(args[1].c_str()),
+        const_cast<char*>(args[2].c_str()),
+        format,
+        package,
+        aeskey);
+    if(n < 0){
+        cout << getError() << endl;
+    }else{
+        cout << "number of packages in graph:" << n << endl;
+    }
+}
+
+void deps(vector<string> args){
+    // [utocPath, ucasPath, package, *mode, *AES key]
+    if(args.size() < 3){
+        cout << "expecting at least 3 arguments for deps" << endl;
+        printHelp();
+        return;
+    }
+    int reverse = 0;
+    int transitive = 0;
+    if(args.size() > 3){
+        string mode = args[3];
+        if(mode == "all"){
+            transitive = 1;
+        } else if(mode == "dependents"){
+            reverse = 1;
+        } else if(mode == "allDependents"){
+            reverse = 1;
+            transitive = 1;
+        } else if(mode != "imports"){
+            cout << "unknown mode " << mode << "; use imports, all, dependents or allDependents" << endl;
+            return;
+        }
+    }
+    char* aeskey = NULL;
+    if(args.size() > 4){
+        aeskey = const_cast<char*>(args[4].c_str());
+    }
+    int n;
+    char** list = listDependencies(const_cast<char*>(args[0].c_str()),
+        const_cast<char*>(args[1].c_str()),
+        const_cast<char*>(args[2].c_str()),
+        reverse, transitive, &n, aeskey);
+    if(list == NULL){
+        cout << getError() << endl;
+        return;
+    }
+    for(int i = 0; i < n; i++){
+        cout << list[i] << endl;
+    }
+    freeStringList(list, n);
+}
+
+void cycles(vector<string> args){
+    // [utocPath, ucasPath, *AES key]
+    if(args.size() < 2){
+        cout << "expecting at least 2 arguments for cycles" << endl;
+        printHelp();
+        return;
+    }
+    char* aeskey = NULL;
+    if(args.size() > 2){
+        aeskey = const_cast<char*>(args[2].c_str());
+    }
+    int n;
+    char** list = listDependencyCycles(const_cast<char*>(args[0].c_str()),
+        const_cast<char*>(args[1].c_str()), &n, aeskey);
+    if(list == NULL){
+        cout << getError() << endl;
+        return;
+    }
+    for(int i = 0; i < n; i++){
+        cout << list[i] << endl;
+    }
+    cout << "number of cycles:" << n << endl;
+    freeStringList(list, n);
+}
+
+void search(vector<string> args){
+    // [outputFile, query, format, utocPath, ucasPath, utocPath, ucasPath, ...]
+    if(args.size() < 5 || (args.size() - 3) % 2 != 0){
+        cout << "expecting an output file, query, format and pairs of .utoc and .ucas files for searching" << endl;
+        printHelp();
+        return;
+    }
+    vector<char*> utocs;
+    vector<char*> ucass;
+    for(size_t i = 3; i < args.size(); i += 2){
+        utocs.push_back(const_cast<char*>(args[i].c_str()));
+        ucass.push_back(const_cast<char*>(args[i+1].c_str()));
+    }
+    int n = searchGameFiles(utocs.data(), ucass.data(), (int)utocs.size(),
+        const_cast<char*>(args[1].c_str()),
+        const_cast<char*>(args[0].c_str()),
+        const_cast<char*>(args[2].c_str()),
+        NULL);
+    if(n < 0){
+        cout << getError() << endl;
+    }else{
+        cout << "number of chunks found:" << n << endl;
+    }
+}
+
+void merge(vector<string> args){
+    // [outputFile, priority, reportFile, utocPath, ucasPath, utocPath, ucasPath, ...]
+    if(args.size() < 5 || (args.size() - 3) % 2 != 0){
+        cout << "expecting an output file, priority, report file and pairs of .utoc and .ucas files for merging" << endl;
+        printHelp();
+        return;
+    }
+    vector<char*> utocs;
+    vector<char*> ucass;
+    for(size_t i = 3; i < args.size(); i += 2){
+        utocs.push_back(const_cast<char*>(args[i].c_str()));
+        ucass.push_back(const_cast<char*>(args[i+1].c_str()));
+    }
+    int n = mergeGameFiles(utocs.data(), ucass.data(), (int)utocs.size(),
+        const_cast<char*>(args[0].c_str()),
+        const_cast<char*>(args[1].c_str()),
+        const_cast<char*>(args[2].c_str()),
+        NULL);
+    if(n < 0){
+        cout << getError() << endl;
+    }else{
+        cout << "number of files merged:" << n << endl;
+    }
+}
+
+void edit(vector<string> args){
+    // [utocPath, ucasPath, outputFile, editsFile, *compressionMethod]
+    if(args.size() < 4){
+        cout << "expecting at least 4 arguments for editing" << endl;
+        printHelp();
+        return;
+    }
+    char* compression = NULL;
+    if(args.size() == 5){
+        compression = const_cast<char*>(args[4].c_str());
+    }
+    int n = editGameFiles(const_cast<char*>(args[0].c_str()),
+        const_cast<char*>(args[1].c_str()),
+        const_cast<char*>(args[2].c_str()),
+        const_cast<char*>(args[3].c_str()),
+        compression,
+        NULL);
+    if(n < 0){
+        cout << getError() << endl;
+    }else{
+        cout << "number of files in container:" << n << endl;
+    }
+}
 
+void transcode(vector<string> args){
+    // [utocPath, ucasPath, outputFile, compressionMethod, *blockSize, *blockAlignment, *AES key]
+    if(args.size() < 4){
+        cout << "expecting at least 4 arguments for transcoding" << endl;
+        printHelp();
+        return;
+    }
+    int blockSize = 0;
+    int blockAlignment = 0;
+    char* aeskey = NULL;
+    if(args.size() > 4){
+        blockSize = (int)stoul(args[4], nullptr, 0);
+    }
+    if(args.size() > 5){
+        blockAlignment = (int)stoul(args[5], nullptr, 0);
+    }
+    if(args.size() > 6){
+        aeskey = const_cast<char*>(args[6].c_str());
+    }
+    int n = transcodeGameFiles(const_cast<char*>(args[0].c_str()),
+        const_cast<char*>(args[1].c_str()),
+        const_cast<char*>(args[2].c_str()),
+        const_cast<char*>(args[3].c_str()),
+        blockSize,
+        blockAlignment,
+        aeskey);
+    if(n < 0){
+        cout << getError() << endl;
+    }else{
+        cout << "number of files transcoded:" << n << endl;
+    }
 }
 
 int main(int argc, char** argv) {
@@ -170,9 +640,76 @@ int main(int argc, char** argv) {
         return 1;
     }
 
-    string feature = argv[1];
+    int first = 1;
+    while (first < argc && string(argv[first]).rfind("--", 0) == 0) {
+        string option = argv[first];
+        if (option == "--oodle") {
+            if (first + 2 >= argc) {
+                cout << "Error: expecting the Oodle library path and a feature" << endl;
+                printHelp();
+                return 1;
+            }
+            if (setOodleLibraryPath(argv[first + 1]) < 0) {
+                cout << getError() << endl;
+                return 1;
+            }
+            first += 2;
+        } else if (option == "--cache") {
+            if (first + 2 >= argc) {
+                cout << "Error: expecting the cache directory and a feature" << endl;
+                printHelp();
+                return 1;
+            }
+            cacheDir = argv[first + 1];
+            first += 2;
+        } else if (option == "--mount") {
+            if (first + 2 >= argc) {
+                cout << "Error: expecting the mount point and a feature" << endl;
+                printHelp();
+                return 1;
+            }
+            mountPoint = argv[first + 1];
+            first += 2;
+        } else if (option == "--sign") {
+            if (first + 2 >= argc) {
+                cout << "Error: expecting the signing key and a feature" << endl;
+                printHelp();
+                return 1;
+            }
+            signingKey = argv[first + 1];
+            first += 2;
+        } else if (option == "--keyguid") {
+            if (first + 2 >= argc) {
+                cout << "Error: expecting the AES key GUID and a feature" << endl;
+                printHelp();
+                return 1;
+            }
+            keyGuid = argv[first + 1];
+            first += 2;
+        } else if (option == "--dedup") {
+            deduplicate = 1;
+            first++;
+        } else if (option == "--discover") {
+            discover = 1;
+            first++;
+        } else if (option == "--subset") {
+            subset = 1;
+            first++;
+        } else {
+            cout << "Error: unknown option " << option << endl;
+            printHelp();
+            return 1;
+        }
+    }
+    if (first >= argc) {
+        cout << "Error: No feature specified" << endl;
+        printHelp();
+        return 1;
+    }
+
+    string feature = argv[first];
     vector<string> args;
-    for (int i = 2; i < argc; i++) {
+    for (int i = first + 1; i < argc; i++) {
         args.push_back(argv[i]);
     }
 
@@ -180,14 +717,44 @@ int main(int argc, char** argv) {
         help(args);
     } else if (feature == "list") {
         list(args);
+    } else if(feature == "chunks"){
+        chunks(args);
+    } else if(feature == "unpackChunk"){
+        unpackChunk(args);
+    } else if(feature == "verify"){
+        verify(args);
     } else if(feature == "unpackAll"){
         unpackAll(args);
     } else if(feature == "unpack"){
         unpack(args);
     } else if(feature == "manifest"){
         manifest(args);
+    } else if(feature == "mergeManifests"){
+        mergeManifestFiles(args);
+    } else if(feature == "subsetManifest"){
+        subsetManifestFile(args);
+    } else if(feature == "validateManifests"){
+        validateManifestFiles(args);
     } else if(feature == "pack") {
         pack(args);
+    } else if(feature == "packDelta") {
+        packDelta(args);
+    } else if(feature == "diff") {
+        diff(args);
+    } else if(feature == "graph") {
+        graph(args);
+    } else if(feature == "deps") {
+        deps(args);
+    } else if(feature == "cycles") {
+        cycles(args);
+    } else if(feature == "search") {
+        search(args);
+    } else if(feature == "merge") {
+        merge(args);
+    } else if(feature == "edit") {
+        edit(args);
+    } else if(feature == "transcode") {
+        transcode(args);
     }else{
         cout << "Error: Invalid feature specified" << endl;
         printHelp();
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// A delta pack only contains the files that differ from the original game container.
+// Modders often unpack the whole container, edit a few files and would otherwise need to
+// remove every untouched file from the manifest by hand before packing.
+// Files that are not present in the directory are treated as unchanged.
+
+// compares the file on disk with the file in the base container
+func fileDiffersFromBase(fpath string, base *UTocData, baseUcas *os.File, f *GameFileMetaData) (bool, error) {
+	b, err := os.ReadFile(fpath)
+	if err != nil {
+		return false, err
+	}
+	if uint64(len(b)) != f.offlen.GetLength() {
+		return true, nil
+	}
+	// an equal hash is enough; a different hash may be caused by a different hashing algorithm, so check the data then.
+	if hashIsSet(&f.metadata.ChunkHash) && *sha1Hash(&b) == f.metadata.ChunkHash {
+		return false, nil
+	}
+	baseData, err := base.readFileData(baseUcas, f)
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(b, *baseData), nil
+}
+
+// deltaManifest constructs the manifest of the base container, trimmed to the files in dir that differ from the base.
+// The .ucas path must point to an unencrypted (or already decrypted) file.
+func deltaManifest(dir string, base *UTocData, baseUcasPath string) (*Manifest, error) {
+	full, err := base.constructManifest(baseUcasPath)
+	if err != nil {
+		return nil, err
+	}
+	baseUcas, err := os.Open(baseUcasPath)
+	if err != nil {
+		return nil, err
+	}
+	defer baseUcas.Close()
+
+	trimmed := Manifest{Version: full.Version, MountPoint: full.MountPoint, Container: full.Container, Deps: full.Deps}
+	entries := make(map[string]ManifestFile)
+	for _, mf := range full.Files {
+		entries[mf.ChunkID] = mf
+	}
+	for i, v := range base.files {
+		mf := entries[v.chunkID.ToHexString()]
+		if v.filepath == DepFileName {
+			trimmed.Files = append(trimmed.Files, mf)
+			continue
+		}
+		if v.filepath == "" {
+			continue
+		}
+		fpath := filepath.Join(dir, base.mountPoint, v.filepath)
+		if _, err := os.Stat(fpath); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		differs, err := fileDiffersFromBase(fpath, base, baseUcas, &base.files[i])
+		if err != nil {
+			return nil, fmt.Errorf("could not compare %s: %w", v.filepath, err)
+		}
+		if differs {
+			trimmed.Files = append(trimmed.Files, mf)
+		}
+	}
+	if len(trimmed.Files) <= 1 {
+		return nil, errors.New("no files differ from the base container; nothing to pack")
+	}
+	return &trimmed, nil
+}
+
+// deltaPackToCasToc packs only the files in dir that differ from the base container.
+// The trimmed manifest, which matches the container header of the new files, is written to outFilename.json
+// When no block size is set, the compression block size of the base container is used.
+func deltaPackToCasToc(dir string, base *UTocData, baseUcasPath string, outFilename string, opts PackOptions) (int, error) {
+	m, err := deltaManifest(dir, base, baseUcasPath)
+	if err != nil {
+		return 0, err
+	}
+	if opts.BlockSize == 0 {
+		opts.BlockSize = base.hdr.CompressionBlockSize
+	}
+	n, err := packToCasToc(dir, m, outFilename, opts)
+	if err != nil {
+		return n, err
+	}
+	// the manifest only gets the dependencies of the packed files, like the container header
+	var packageIDs []uint64
+	for _, f := range m.Files {
+		packageIDs = append(packageIDs, FromHexString(f.ChunkID).ID)
+	}
+	m.Deps = m.Deps.subset(packageIDs)
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return n, err
+	}
+	err = ioutil.WriteFile(outFilename+".json", b, fs.ModePerm)
+	return n, err
+}
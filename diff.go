@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// This file compares two .utoc/.ucas containers, for example the game files before and after a patch.
+// Chunks are matched by their path below the root of the game first; chunks that were moved are matched by their chunk ID.
+// Chunks without a path, such as those in global.utoc, are only matched by their chunk ID.
+// Whether a chunk was modified is decided with the ChunkHash in the chunk metas, if both containers have one.
+// Otherwise, the decompressed data of both chunks is compared.
+
+const (
+	DiffReasonHash    = "hash"
+	DiffReasonContent = "content"
+	DiffReasonSize    = "size"
+	DiffReasonChunkID = "chunkId"
+	DiffReasonPath    = "path"
+)
+
+type ChunkChange struct {
+	Path       string   `json:"path"`
+	OldPath    string   `json:"oldPath,omitempty"`
+	ChunkID    string   `json:"chunkId"`
+	OldChunkID string   `json:"oldChunkId,omitempty"`
+	Size       uint64   `json:"size"`
+	OldSize    uint64   `json:"oldSize,omitempty"`
+	Reasons    []string `json:"reasons,omitempty"` // only set for modified chunks
+}
+
+type DependencyChange struct {
+	PackageID           uint64   `json:"packageId"`
+	Path                string   `json:"path,omitempty"`
+	AddedDependencies   []uint64 `json:"addedDependencies,omitempty"`
+	RemovedDependencies []uint64 `json:"removedDependencies,omitempty"`
+	ChangedFields       []string `json:"changedFields,omitempty"`
+}
+
+type ContainerDiff struct {
+	Added           []ChunkChange      `json:"added"`
+	Removed         []ChunkChange      `json:"removed"`
+	Modified        []ChunkChange      `json:"modified"`
+	AddedPackages   []DependencyChange `json:"addedPackages"`
+	RemovedPackages []DependencyChange `json:"removedPackages"`
+	ChangedPackages []DependencyChange `json:"changedPackages"`
+}
+
+// a container that is opened for diffing; the .ucas file is read when the hashes are not sufficient
+type diffSide struct {
+	toc  *UTocData
+	ucas *os.File
+	deps *Dependencies
+}
+
+func (s *diffSide) pathOfPackage(id uint64) string {
+	for i, f := range s.toc.files {
+		if f.chunkID.ID == id && f.filepath != DepFileName && f.filepath != "" {
+			return s.toc.gamePath(&s.toc.files[i])
+		}
+	}
+	return ""
+}
+
+func hashIsSet(h *FIoChunkHash) bool {
+	return h.Hash != [20]uint8{}
+}
+
+// compares two chunks and returns the reasons why they differ, if any
+func compareChunks(oldSide *diffSide, oldFile *GameFileMetaData, newSide *diffSide, newFile *GameFileMetaData) ([]string, error) {
+	var reasons []string
+	if oldSide.toc.gamePath(oldFile) != newSide.toc.gamePath(newFile) {
+		reasons = append(reasons, DiffReasonPath)
+	}
+	if oldFile.chunkID != newFile.chunkID {
+		reasons = append(reasons, DiffReasonChunkID)
+	}
+	if oldFile.offlen.GetLength() != newFile.offlen.GetLength() {
+		return append(reasons, DiffReasonSize), nil
+	}
+	if hashIsSet(&oldFile.metadata.ChunkHash) && hashIsSet(&newFile.metadata.ChunkHash) {
+		if oldFile.metadata.ChunkHash != newFile.metadata.ChunkHash {
+			reasons = append(reasons, DiffReasonHash)
+		}
+		return reasons, nil
+	}
+	oldData, err := oldSide.toc.readFileData(oldSide.ucas, oldFile)
+	if err != nil {
+		return nil, err
+	}
+	newData, err := newSide.toc.readFileData(newSide.ucas, newFile)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(*oldData, *newData) {
+		reasons = append(reasons, DiffReasonContent)
+	}
+	return reasons, nil
+}
+
+func diffUint64Slices(oldList, newList []uint64) (added, removed []uint64) {
+	oldSet := make(map[uint64]bool)
+	for _, v := range oldList {
+		oldSet[v] = true
+	}
+	newSet := make(map[uint64]bool)
+	for _, v := range newList {
+		newSet[v] = true
+		if !oldSet[v] {
+			added = append(added, v)
+		}
+	}
+	for _, v := range oldList {
+		if !newSet[v] {
+			removed = append(removed, v)
+		}
+	}
+	return added, removed
+}
+
+func sortedPackageIDs(m map[uint64]FileDependency) []uint64 {
+	ids := []uint64{}
+	for k := range m {
+		ids = append(ids, k)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+func (d *ContainerDiff) diffDependencies(oldSide, newSide *diffSide) {
+	oldDeps := oldSide.deps.ChunkIDToDependencies
+	newDeps := newSide.deps.ChunkIDToDependencies
+	for _, id := range sortedPackageIDs(newDeps) {
+		newEntry := newDeps[id]
+		oldEntry, ok := oldDeps[id]
+		if !ok {
+			d.AddedPackages = append(d.AddedPackages, DependencyChange{
+				PackageID:         id,
+				Path:              newSide.pathOfPackage(id),
+				AddedDependencies: newEntry.Dependencies,
+			})
+			continue
+		}
+		change := DependencyChange{PackageID: id, Path: newSide.pathOfPackage(id)}
+		change.AddedDependencies, change.RemovedDependencies = diffUint64Slices(oldEntry.Dependencies, newEntry.Dependencies)
+		if oldEntry.FileSize != newEntry.FileSize {
+			change.ChangedFields = append(change.ChangedFields, "uncompressedSize")
+		}
+		if oldEntry.ExportObjects != newEntry.ExportObjects {
+			change.ChangedFields = append(change.ChangedFields, "exportObjects")
+		}
+		if oldEntry.MostlyOne != newEntry.MostlyOne {
+			change.ChangedFields = append(change.ChangedFields, "requiredValueSomehow")
+		}
+		if len(change.AddedDependencies) != 0 || len(change.RemovedDependencies) != 0 || len(change.ChangedFields) != 0 {
+			d.ChangedPackages = append(d.ChangedPackages, change)
+		}
+	}
+	for _, id := range sortedPackageIDs(oldDeps) {
+		if _, ok := newDeps[id]; !ok {
+			d.RemovedPackages = append(d.RemovedPackages, DependencyChange{
+				PackageID:           id,
+				Path:                oldSide.pathOfPackage(id),
+				RemovedDependencies: oldDeps[id].Dependencies,
+			})
+		}
+	}
+}
+
+// diffContainers compares the old container with the new one.
+// The .ucas paths must point to unencrypted (or already decrypted) files.
+func diffContainers(oldToc *UTocData, oldUcasPath string, newToc *UTocData, newUcasPath string) (*ContainerDiff, error) {
+	oldUcas, err := os.Open(oldUcasPath)
+	if err != nil {
+		return nil, err
+	}
+	defer oldUcas.Close()
+	newUcas, err := os.Open(newUcasPath)
+	if err != nil {
+		return nil, err
+	}
+	defer newUcas.Close()
+	oldSide := diffSide{toc: oldToc, ucas: oldUcas}
+	newSide := diffSide{toc: newToc, ucas: newUcas}
+
+	// the dependencies "file" is compared separately, as package dependencies
+	oldByPath := make(map[string]*GameFileMetaData)
+	oldByChunkID := make(map[FIoChunkID]*GameFileMetaData)
+	for i, f := range oldToc.files {
+		if f.filepath == DepFileName {
+			continue
+		}
+		if f.filepath != "" {
+			oldByPath[oldToc.gamePath(&oldToc.files[i])] = &oldToc.files[i]
+		}
+		oldByChunkID[f.chunkID] = &oldToc.files[i]
+	}
+	matched := make(map[*GameFileMetaData]bool)
+	diff := ContainerDiff{
+		Added:           []ChunkChange{},
+		Removed:         []ChunkChange{},
+		Modified:        []ChunkChange{},
+		AddedPackages:   []DependencyChange{},
+		RemovedPackages: []DependencyChange{},
+		ChangedPackages: []DependencyChange{},
+	}
+
+	// pair every new chunk with an old one; first by path, then by chunk ID
+	pairs := make(map[*GameFileMetaData]*GameFileMetaData)
+	var newFiles []*GameFileMetaData
+	for i, f := range newToc.files {
+		if f.filepath == DepFileName {
+			continue
+		}
+		newFiles = append(newFiles, &newToc.files[i])
+		if old, ok := oldByPath[newToc.gamePath(&newToc.files[i])]; ok && f.filepath != "" {
+			pairs[&newToc.files[i]] = old
+			matched[old] = true
+		}
+	}
+	for _, f := range newFiles {
+		if _, ok := pairs[f]; ok {
+			continue
+		}
+		if old, ok := oldByChunkID[f.chunkID]; ok && !matched[old] {
+			pairs[f] = old
+			matched[old] = true
+		}
+	}
+
+	for _, f := range newFiles {
+		old, ok := pairs[f]
+		if !ok {
+			diff.Added = append(diff.Added, ChunkChange{
+				Path:    newToc.gamePath(f),
+				ChunkID: f.chunkID.ToHexString(),
+				Size:    f.offlen.GetLength(),
+			})
+			continue
+		}
+		reasons, err := compareChunks(&oldSide, old, &newSide, f)
+		if err != nil {
+			return nil, fmt.Errorf("could not compare %s: %w", newToc.gamePath(f), err)
+		}
+		if len(reasons) == 0 {
+			continue
+		}
+		change := ChunkChange{
+			Path:    newToc.gamePath(f),
+			ChunkID: f.chunkID.ToHexString(),
+			Size:    f.offlen.GetLength(),
+			OldSize: old.offlen.GetLength(),
+			Reasons: reasons,
+		}
+		if oldPath := oldToc.gamePath(old); oldPath != change.Path {
+			change.OldPath = oldPath
+		}
+		if old.chunkID != f.chunkID {
+			change.OldChunkID = old.chunkID.ToHexString()
+		}
+		diff.Modified = append(diff.Modified, change)
+	}
+	for i, f := range oldToc.files {
+		if f.filepath == DepFileName || matched[&oldToc.files[i]] {
+			continue
+		}
+		diff.Removed = append(diff.Removed, ChunkChange{
+			Path:    oldToc.gamePath(&oldToc.files[i]),
+			ChunkID: f.chunkID.ToHexString(),
+			Size:    f.offlen.GetLength(),
+		})
+	}
+	for _, changes := range [][]ChunkChange{diff.Added, diff.Removed, diff.Modified} {
+		sort.SliceStable(changes, func(i, j int) bool {
+			return changes[i].Path < changes[j].Path
+		})
+	}
+
+	// compare the dependencies of both containers; a container without a container header has no packages
+	for _, side := range []*diffSide{&oldSide, &newSide} {
+		if !side.toc.hasDependencies() {
+			side.deps = &Dependencies{ChunkIDToDependencies: make(map[uint64]FileDependency)}
+			continue
+		}
+		data, err := side.toc.unpackDependencies(side.ucas.Name())
+		if err != nil {
+			return nil, err
+		}
+		side.deps, err = ParseDependencies(*data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	diff.diffDependencies(&oldSide, &newSide)
+	return &diff, nil
+}
+
+func (d *ContainerDiff) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(d, "", "  ")
+}
+
+func formatPackageIDs(ids []uint64) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = fmt.Sprintf("%016x", id)
+	}
+	return strings.Join(s, ", ")
+}
+
+// ToText formats the diff in a human readable way; one line per change.
+func (d *ContainerDiff) ToText() string {
+	var sb strings.Builder
+	for _, c := range d.Added {
+		fmt.Fprintf(&sb, "A %s [%s] (%d bytes)\n", c.Path, c.ChunkID, c.Size)
+	}
+	for _, c := range d.Removed {
+		fmt.Fprintf(&sb, "D %s [%s] (%d bytes)\n", c.Path, c.ChunkID, c.Size)
+	}
+	for _, c := range d.Modified {
+		fmt.Fprintf(&sb, "M %s [%s] (%d -> %d bytes; %s)", c.Path, c.ChunkID, c.OldSize, c.Size, strings.Join(c.Reasons, ", "))
+		if c.OldPath != "" {
+			fmt.Fprintf(&sb, " was %s", c.OldPath)
+		}
+		if c.OldChunkID != "" {
+			fmt.Fprintf(&sb, " was [%s]", c.OldChunkID)
+		}
+		sb.WriteString("\n")
+	}
+	for _, p := range d.AddedPackages {
+		fmt.Fprintf(&sb, "A package %016x %s\n", p.PackageID, p.Path)
+	}
+	for _, p := range d.RemovedPackages {
+		fmt.Fprintf(&sb, "D package %016x %s\n", p.PackageID, p.Path)
+	}
+	for _, p := range d.ChangedPackages {
+		fmt.Fprintf(&sb, "M package %016x %s\n", p.PackageID, p.Path)
+		if len(p.AddedDependencies) != 0 {
+			fmt.Fprintf(&sb, "    + dependencies: %s\n", formatPackageIDs(p.AddedDependencies))
+		}
+		if len(p.RemovedDependencies) != 0 {
+			fmt.Fprintf(&sb, "    - dependencies: %s\n", formatPackageIDs(p.RemovedDependencies))
+		}
+		if len(p.ChangedFields) != 0 {
+			fmt.Fprintf(&sb, "    changed: %s\n", strings.Join(p.ChangedFields, ", "))
+		}
+	}
+	fmt.Fprintf(&sb, "%d added, %d removed, %d modified; %d packages added, %d removed, %d changed\n",
+		len(d.Added), len(d.Removed), len(d.Modified), len(d.AddedPackages), len(d.RemovedPackages), len(d.ChangedPackages))
+	return sb.String()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Packing reads every file of the manifest from its path below the directory, which mirrors the root of the game.
+// Mod folders often have a different layout, such as the layout of .pak mods (MyMod_P/Phoenix/Content/...),
+// an extra directory at the top, or a different case. With discovery, a file that is not at its path is searched
+// in the whole directory: the file whose path has the longest end in common with the path in the manifest is used,
+// so a file can be found by its name alone. Files that match equally well are ambiguous and give an error.
+// The paths in the container are always those of the manifest.
+// When a subset is packed, the files of the manifest that are not found are left out instead of giving an error.
+
+// packSources maps the paths of the files below the root of the game to the files that are packed
+type packSources map[string]string
+
+// locatePackFiles finds the files that are packed. The paths start with a slash and are relative to the root
+// of the game; the dependencies don't have a file and are ignored. The paths of the files that are missing are returned.
+func locatePackFiles(dir string, paths []string, discover bool) (packSources, []string, error) {
+	sources := make(packSources)
+	var wanted []string
+	exact := make(map[string]bool)
+	for _, p := range paths {
+		if p == DepFileName {
+			continue
+		}
+		fpath := filepath.Join(dir, p)
+		if info, err := os.Stat(fpath); err == nil && !info.IsDir() {
+			sources[p] = fpath
+			exact[fpath] = true
+			continue
+		}
+		wanted = append(wanted, p)
+	}
+	if !discover || len(wanted) == 0 {
+		return sources, wanted, nil
+	}
+
+	// the files below dir by their lowercase name; files that are found at their own path are not searched
+	byName := make(map[string][]string)
+	var found []string
+	err := filepath.Walk(dir, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		found = append(found, fpath)
+		if !exact[fpath] {
+			name := strings.ToLower(info.Name())
+			byName[name] = append(byName[name], fpath)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var missing []string
+	var problems []string
+	matchedBy := make(map[string]string)
+	for _, p := range wanted {
+		var best []string
+		bestLength := 0
+		for _, candidate := range byName[strings.ToLower(filepath.Base(p))] {
+			rel, _ := filepath.Rel(dir, candidate)
+			n := commonPathSuffix(p, filepath.ToSlash(rel))
+			if n > bestLength {
+				best, bestLength = nil, n
+			}
+			if n == bestLength {
+				best = append(best, candidate)
+			}
+		}
+		switch {
+		case len(best) == 0:
+			missing = append(missing, p)
+		case len(best) > 1:
+			problems = append(problems, fmt.Sprintf("%s is ambiguous; it matches %s", p, strings.Join(best, ", ")))
+		case matchedBy[best[0]] != "":
+			problems = append(problems, fmt.Sprintf("%s matches both %s and %s", best[0], matchedBy[best[0]], p))
+		default:
+			sources[p] = best[0]
+			matchedBy[best[0]] = p
+			fmt.Println("Found: ", p, "at", best[0])
+		}
+	}
+	if len(problems) != 0 {
+		return nil, nil, errors.New(strings.Join(problems, "\n"))
+	}
+	for _, fpath := range found {
+		if !exact[fpath] && matchedBy[fpath] == "" {
+			fmt.Println("Warning: " + fpath + " is not in the manifest and is not packed")
+		}
+	}
+	return sources, missing, nil
+}
+
+// commonPathSuffix returns the number of directories and names at the end of both paths that are the same, ignoring the case
+func commonPathSuffix(a string, b string) int {
+	as := strings.Split(strings.Trim(a, "/"), "/")
+	bs := strings.Split(strings.Trim(b, "/"), "/")
+	n := 0
+	for n < len(as) && n < len(bs) && strings.EqualFold(as[len(as)-1-n], bs[len(bs)-1-n]) {
+		n++
+	}
+	return n
+}
+
+// missingFilesError lists the files of the manifest that were not found
+func missingFilesError(dir string, missing []string) error {
+	sort.Strings(missing)
+	const shown = 10
+	list := missing
+	if len(list) > shown {
+		list = list[:shown]
+	}
+	msg := fmt.Sprintf("%d files of the manifest were not found in %s: %s", len(missing), dir, strings.Join(list, ", "))
+	if len(missing) > shown {
+		msg += fmt.Sprintf(" and %d more", len(missing)-shown)
+	}
+	return errors.New(msg + "; pack a subset to leave them out")
+}
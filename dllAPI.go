@@ -4,9 +4,9 @@ package main
 // #include <stdlib.h>
 import "C"
 import (
+	"crypto/rsa"
 	"embed" // for the .pak file
 	"encoding/json"
-	"fmt"
 	"io/fs"
 	"io/ioutil"
 	"os"
@@ -24,6 +24,28 @@ var embeddedFiles embed.FS
 
 //export packGameFiles
 func packGameFiles(dirPath *C.char, manifestPath *C.char, outFile *C.char, compressionMethod *C.char, AESKey *C.char) C.int {
+	options := PackOptionsJSON{}
+	if compressionMethod != nil {
+		options.Compression = C.GoString(compressionMethod)
+	}
+	if AESKey != nil {
+		options.AESKey = C.GoString(AESKey)
+	}
+	return packGameFilesWith(dirPath, manifestPath, outFile, options)
+}
+
+//export packGameFilesWithOptions
+func packGameFilesWithOptions(dirPath *C.char, manifestPath *C.char, outFile *C.char, options *C.char) C.int {
+	o, err := convertPackOptions(options)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	return packGameFilesWith(dirPath, manifestPath, outFile, o)
+}
+
+// packGameFilesWith packs like packGameFiles with all options, see PackOptionsJSON
+func packGameFilesWith(dirPath *C.char, manifestPath *C.char, outFile *C.char, options PackOptionsJSON) C.int {
 	dir := C.GoString(dirPath)
 	dir, err := filepath.Abs(dir)
 	if err != nil {
@@ -33,20 +55,95 @@ func packGameFiles(dirPath *C.char, manifestPath *C.char, outFile *C.char, compr
 	manifestFile := C.GoString(manifestPath)
 	outPath := C.GoString(outFile)
 	outPath = strings.TrimSuffix(outPath, filepath.Ext(outPath)) // remove any extension
-	compression := "None"
+	manifest, err := readManifest(manifestFile)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	// the key GUID of the original container is used when none is passed
+	manifestGuid, _ := manifest.Container.keyGuid()
+	opts, err := options.packOptions(manifestGuid)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	n, err := packToCasToc(dir, manifest, outPath, opts)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	// write the embedded .pak file
+	embedded, _ := embeddedFiles.ReadFile("req/Packed_P.pak")
+	os.WriteFile(outPath+".pak", embedded, os.ModePerm)
+	return C.int(n - 1) // correction for dependencies file
+}
+
+//export packGameFilesDelta
+func packGameFilesDelta(dirPath *C.char, baseUtocFile *C.char, baseUcasFile *C.char, outFile *C.char, compressionMethod *C.char, AESKey *C.char) C.int {
+	options := PackOptionsJSON{}
 	if compressionMethod != nil {
-		compression = C.GoString(compressionMethod)
+		options.Compression = C.GoString(compressionMethod)
 	}
-	aes := convertAES(AESKey)
-	if len(aes) != 0 && len(aes) != 32 {
-		staticErr = "AES key length should be 32, or none at all"
+	if AESKey != nil {
+		options.AESKey = C.GoString(AESKey)
 	}
-	manifest, err := readManifest(manifestFile)
+	return packGameFilesDeltaWith(dirPath, baseUtocFile, baseUcasFile, outFile, options)
+}
+
+//export packGameFilesDeltaWithOptions
+func packGameFilesDeltaWithOptions(dirPath *C.char, baseUtocFile *C.char, baseUcasFile *C.char, outFile *C.char, options *C.char) C.int {
+	o, err := convertPackOptions(options)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	return packGameFilesDeltaWith(dirPath, baseUtocFile, baseUcasFile, outFile, o)
+}
+
+// packGameFilesDeltaWith packs like packGameFilesDelta with all options, see PackOptionsJSON.
+// The AES key decrypts the base container; the packed files are not encrypted.
+func packGameFilesDeltaWith(dirPath *C.char, baseUtocFile *C.char, baseUcasFile *C.char, outFile *C.char, options PackOptionsJSON) C.int {
+	dir := C.GoString(dirPath)
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	utocFname := C.GoString(baseUtocFile)
+	ucasFname := C.GoString(baseUcasFile)
+	outPath := C.GoString(outFile)
+	outPath = strings.TrimSuffix(outPath, filepath.Ext(outPath)) // remove any extension
+	if options.AESKeyGuid != "" || options.Discover || options.Subset {
+		staticErr = "AESKeyGuid, Discover and Subset can not be used when delta packing"
+		return C.int(-1)
+	}
+	keys, err := ParseKeyring(options.AESKey)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+
+	d, err := parseUtocFile(utocFname, keys)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	if d.hdr.isEncrypted() {
+		ucasFname, err = d.decryptUcasToTempFile(ucasFname)
+		if err != nil {
+			staticErr = err.Error()
+			return C.int(-1)
+		}
+		defer os.Remove(ucasFname)
+	}
+	// the base container may be encrypted, the mod is not encrypted
+	options.AESKey = ""
+	opts, err := options.packOptions(FGuid{})
 	if err != nil {
 		staticErr = err.Error()
 		return C.int(-1)
 	}
-	n, err := packToCasToc(dir, manifest, outPath, compression, aes)
+	n, err := deltaPackToCasToc(dir, d, ucasFname, outPath, opts)
 	if err != nil {
 		staticErr = err.Error()
 		return C.int(-1)
@@ -69,9 +166,14 @@ func freeStringList(stringlist **C.char, n C.int) {
 //export listGameFiles
 func listGameFiles(utocFile *C.char, n *C.int, AESKey *C.char) (strlist **C.char) {
 	utocFname := C.GoString(utocFile)
-	aes := convertAES(AESKey)
+	keys, err := convertKeyring(AESKey)
+	if err != nil {
+		staticErr = err.Error()
+		*n = C.int(-1)
+		return nil
+	}
 
-	d, err := parseUtocFile(utocFname, aes)
+	d, err := parseUtocFile(utocFname, keys)
 	if err != nil {
 		staticErr = err.Error()
 		*n = C.int(-1)
@@ -80,7 +182,7 @@ func listGameFiles(utocFile *C.char, n *C.int, AESKey *C.char) (strlist **C.char
 
 	filepaths := []string{}
 	for _, v := range d.files {
-		if v.filepath == DepFileName {
+		if v.filepath == DepFileName || v.filepath == "" {
 			continue
 		}
 		filepaths = append(filepaths, v.filepath)
@@ -90,6 +192,27 @@ func listGameFiles(utocFile *C.char, n *C.int, AESKey *C.char) (strlist **C.char
 	return strSliceToC(&filepaths)
 }
 
+//export listGameChunks
+func listGameChunks(utocFile *C.char, n *C.int, AESKey *C.char) (strlist **C.char) {
+	utocFname := C.GoString(utocFile)
+	keys, err := convertKeyring(AESKey)
+	if err != nil {
+		staticErr = err.Error()
+		*n = C.int(-1)
+		return nil
+	}
+
+	d, err := parseUtocFile(utocFname, keys)
+	if err != nil {
+		staticErr = err.Error()
+		*n = C.int(-1)
+		return nil
+	}
+	chunks := d.listChunks()
+	*n = C.int(len(chunks))
+	return strSliceToC(&chunks)
+}
+
 //export getError
 func getError() (err *C.char) {
 	return C.CString(staticErr)
@@ -101,55 +224,561 @@ func createManifestFile(utocFile *C.char, ucasFile *C.char, outputFile *C.char,
 	utocFname := C.GoString(utocFile)
 	ucasFname := C.GoString(ucasFile)
 	outputFname := C.GoString(outputFile)
-	aes := convertAES(AESKey)
+	keys, err := convertKeyring(AESKey)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
 
-	d, err := parseUtocFile(utocFname, aes)
+	d, err := parseUtocFile(utocFname, keys)
 	if err != nil {
 		staticErr = err.Error()
 		return C.int(-1)
 	}
 	
-	if d.hdr.isEncrypted(){
-		tmpFile, err := os.CreateTemp("", "tmp")
+	if d.hdr.isEncrypted() {
+		ucasFname, err = d.decryptUcasToTempFile(ucasFname)
 		if err != nil {
 			staticErr = err.Error()
 			return C.int(-1)
 		}
-		ucasBytes, err := ioutil.ReadFile(ucasFname)
+		defer os.Remove(ucasFname)
+	}
+	manifest, err := d.constructManifest(ucasFname)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	b, err := json.MarshalIndent(manifest, "", "  ") // indent for readability
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	err = ioutil.WriteFile(outputFname, b, fs.ModePerm)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+
+	return C.int(0)
+}
+
+//export mergeManifests
+func mergeManifests(manifestFiles **C.char, n C.int, outputFile *C.char, priority *C.char, reportFile *C.char) C.int {
+	mergePriority := MergePriorityFirst
+	if priority != nil {
+		mergePriority = C.GoString(priority)
+	}
+	set, err := readManifestSet(cStrSliceToGo(manifestFiles, n), mergePriority)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	m := set.Manifest()
+	err = writeManifest(m, C.GoString(outputFile))
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	if reportFile != nil {
+		report := MergeReport{Files: len(m.Files), Conflicts: set.Conflicts}
+		b, err := report.ToJSON()
 		if err != nil {
 			staticErr = err.Error()
 			return C.int(-1)
 		}
-		decryptedBytes, err := decryptAES(&ucasBytes, aes)
+		err = ioutil.WriteFile(C.GoString(reportFile), b, fs.ModePerm)
 		if err != nil {
 			staticErr = err.Error()
 			return C.int(-1)
 		}
-		tmpFile.Write(*decryptedBytes)
-		ucasFname = tmpFile.Name()
-		err = tmpFile.Close()
+	}
+	return C.int(len(m.Files))
+}
+
+//export subsetManifest
+func subsetManifest(manifestFiles **C.char, n C.int, pathsFile *C.char, withDependencies C.int, outputFile *C.char) C.int {
+	set, err := readManifestSet(cStrSliceToGo(manifestFiles, n), MergePriorityFirst)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	paths, err := readPathList(C.GoString(pathsFile))
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	m, err := set.Subset(paths, withDependencies != 0)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	err = writeManifest(m, C.GoString(outputFile))
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	return C.int(len(m.Files))
+}
+
+//export validateManifests
+func validateManifests(manifestFiles **C.char, n C.int) C.int {
+	set, err := readManifestSet(cStrSliceToGo(manifestFiles, n), MergePriorityFirst)
+	if err == nil {
+		err = set.Validate()
+	}
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	return C.int(0)
+}
+
+//export exportDependencyGraph
+func exportDependencyGraph(utocFile *C.char, ucasFile *C.char, outputFile *C.char, format *C.char, rootPackage *C.char, AESKey *C.char) C.int {
+	keys, err := convertKeyring(AESKey)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	g, err := readDependencyGraph(C.GoString(utocFile), C.GoString(ucasFile), keys)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	if root := C.GoString(rootPackage); root != "" {
+		id, err := g.Find(root)
 		if err != nil {
-			fmt.Println("err:", err)
+			staticErr = err.Error()
 			return C.int(-1)
 		}
-		defer os.Remove(tmpFile.Name())
+		g = g.Neighbourhood(id)
 	}
-	manifest, err := d.constructManifest(ucasFname)
+	b, err := g.Format(C.GoString(format))
 	if err != nil {
 		staticErr = err.Error()
 		return C.int(-1)
 	}
-	b, err := json.MarshalIndent(manifest, "", "  ") // indent for readability
+	err = ioutil.WriteFile(C.GoString(outputFile), b, fs.ModePerm)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	return C.int(len(g.Packages()))
+}
+
+//export listDependencies
+func listDependencies(utocFile *C.char, ucasFile *C.char, packageName *C.char, reverse C.int, transitive C.int, n *C.int, AESKey *C.char) (strlist **C.char) {
+	keys, err := convertKeyring(AESKey)
+	if err != nil {
+		staticErr = err.Error()
+		*n = C.int(-1)
+		return nil
+	}
+	g, err := readDependencyGraph(C.GoString(utocFile), C.GoString(ucasFile), keys)
+	if err != nil {
+		staticErr = err.Error()
+		*n = C.int(-1)
+		return nil
+	}
+	id, err := g.Find(C.GoString(packageName))
+	if err != nil {
+		staticErr = err.Error()
+		*n = C.int(-1)
+		return nil
+	}
+	var ids []uint64
+	switch {
+	case reverse != 0 && transitive != 0:
+		ids = g.TransitiveDependents(id)
+	case reverse != 0:
+		ids = g.Dependents(id)
+	case transitive != 0:
+		ids = g.TransitiveDependencies(id)
+	default:
+		ids = g.Dependencies(id)
+	}
+	paths := []string{}
+	for _, dep := range ids {
+		paths = append(paths, g.Path(dep))
+	}
+	*n = C.int(len(paths))
+	return strSliceToC(&paths)
+}
+
+//export listDependencyCycles
+func listDependencyCycles(utocFile *C.char, ucasFile *C.char, n *C.int, AESKey *C.char) (strlist **C.char) {
+	keys, err := convertKeyring(AESKey)
+	if err != nil {
+		staticErr = err.Error()
+		*n = C.int(-1)
+		return nil
+	}
+	g, err := readDependencyGraph(C.GoString(utocFile), C.GoString(ucasFile), keys)
+	if err != nil {
+		staticErr = err.Error()
+		*n = C.int(-1)
+		return nil
+	}
+	// each line one group of packages that depend on each other
+	cycles := []string{}
+	for _, cycle := range g.Cycles() {
+		paths := []string{}
+		for _, id := range cycle {
+			paths = append(paths, g.Path(id))
+		}
+		cycles = append(cycles, strings.Join(paths, ", "))
+	}
+	*n = C.int(len(cycles))
+	return strSliceToC(&cycles)
+}
+
+//export diffGameFiles
+func diffGameFiles(oldUtocFile *C.char, oldUcasFile *C.char, newUtocFile *C.char, newUcasFile *C.char, outputFile *C.char, format *C.char, AESKey *C.char) C.int {
+	utocFnames := []string{C.GoString(oldUtocFile), C.GoString(newUtocFile)}
+	ucasFnames := []string{C.GoString(oldUcasFile), C.GoString(newUcasFile)}
+	outputFname := C.GoString(outputFile)
+	outputFormat := "text"
+	if format != nil {
+		outputFormat = strings.ToLower(C.GoString(format))
+	}
+	keys, err := convertKeyring(AESKey)
 	if err != nil {
 		staticErr = err.Error()
 		return C.int(-1)
 	}
+
+	var tocs []*UTocData
+	for i := range utocFnames {
+		d, err := parseUtocFile(utocFnames[i], keys)
+		if err != nil {
+			staticErr = err.Error()
+			return C.int(-1)
+		}
+		if d.hdr.isEncrypted() {
+			ucasFnames[i], err = d.decryptUcasToTempFile(ucasFnames[i])
+			if err != nil {
+				staticErr = err.Error()
+				return C.int(-1)
+			}
+			defer os.Remove(ucasFnames[i])
+		}
+		tocs = append(tocs, d)
+	}
+	diff, err := diffContainers(tocs[0], ucasFnames[0], tocs[1], ucasFnames[1])
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	var b []byte
+	switch outputFormat {
+	case "json":
+		b, err = diff.ToJSON()
+		if err != nil {
+			staticErr = err.Error()
+			return C.int(-1)
+		}
+	case "text":
+		b = []byte(diff.ToText())
+	default:
+		staticErr = "unknown diff format " + outputFormat + "; use text or json"
+		return C.int(-1)
+	}
 	err = ioutil.WriteFile(outputFname, b, fs.ModePerm)
 	if err != nil {
 		staticErr = err.Error()
 		return C.int(-1)
 	}
+	return C.int(len(diff.Added) + len(diff.Removed) + len(diff.Modified))
+}
+
+//export mergeGameFiles
+func mergeGameFiles(utocFiles **C.char, ucasFiles **C.char, n C.int, outFile *C.char, priority *C.char, reportFile *C.char, AESKey *C.char) C.int {
+	utocFnames := cStrSliceToGo(utocFiles, n)
+	ucasFnames := cStrSliceToGo(ucasFiles, n)
+	outPath := C.GoString(outFile)
+	outPath = strings.TrimSuffix(outPath, filepath.Ext(outPath)) // remove any extension
+	mergePriority := MergePriorityFirst
+	if priority != nil {
+		mergePriority = C.GoString(priority)
+	}
+	keys, err := convertKeyring(AESKey)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+
+	var sources []MergeSource
+	for i := range utocFnames {
+		d, err := parseUtocFile(utocFnames[i], keys)
+		if err != nil {
+			staticErr = utocFnames[i] + ": " + err.Error()
+			return C.int(-1)
+		}
+		if d.hdr.isEncrypted() {
+			ucasFnames[i], err = d.decryptUcasToTempFile(ucasFnames[i])
+			if err != nil {
+				staticErr = err.Error()
+				return C.int(-1)
+			}
+			defer os.Remove(ucasFnames[i])
+		}
+		sources = append(sources, MergeSource{name: utocFnames[i], toc: d, ucasPath: ucasFnames[i]})
+	}
+	report, err := mergeContainers(sources, mergePriority, outPath)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	if reportFile != nil {
+		b, err := report.ToJSON()
+		if err != nil {
+			staticErr = err.Error()
+			return C.int(-1)
+		}
+		err = ioutil.WriteFile(C.GoString(reportFile), b, fs.ModePerm)
+		if err != nil {
+			staticErr = err.Error()
+			return C.int(-1)
+		}
+	}
+	// write the embedded .pak file
+	embedded, _ := embeddedFiles.ReadFile("req/Packed_P.pak")
+	os.WriteFile(outPath+".pak", embedded, os.ModePerm)
+	return C.int(report.Files)
+}
+
+//export searchGameFiles
+func searchGameFiles(utocFiles **C.char, ucasFiles **C.char, n C.int, query *C.char, outputFile *C.char, format *C.char, AESKey *C.char) C.int {
+	utocFnames := cStrSliceToGo(utocFiles, n)
+	ucasFnames := cStrSliceToGo(ucasFiles, n)
+	outputFormat := "json"
+	if format != nil {
+		outputFormat = strings.ToLower(C.GoString(format))
+	}
+	q, err := ParseAssetQuery(C.GoString(query))
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	keys, err := convertKeyring(AESKey)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+
+	var sources []MergeSource
+	for i := range utocFnames {
+		d, err := parseUtocFile(utocFnames[i], keys)
+		if err != nil {
+			staticErr = utocFnames[i] + ": " + err.Error()
+			return C.int(-1)
+		}
+		// most conditions only need the .utoc file, so the .ucas file is only decrypted when it is read
+		if d.hdr.isEncrypted() && q.needsUcas(d) {
+			ucasFnames[i], err = d.decryptUcasToTempFile(ucasFnames[i])
+			if err != nil {
+				staticErr = err.Error()
+				return C.int(-1)
+			}
+			defer os.Remove(ucasFnames[i])
+		}
+		sources = append(sources, MergeSource{name: utocFnames[i], toc: d, ucasPath: ucasFnames[i]})
+	}
+	matches, err := searchContainers(sources, q)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	var b []byte
+	switch outputFormat {
+	case "json":
+		b, err = searchResultsToJSON(matches)
+		if err != nil {
+			staticErr = err.Error()
+			return C.int(-1)
+		}
+	case "text":
+		b = []byte(searchResultsToText(matches))
+	default:
+		staticErr = "unknown search result format " + outputFormat + "; use json or text"
+		return C.int(-1)
+	}
+	err = ioutil.WriteFile(C.GoString(outputFile), b, fs.ModePerm)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	return C.int(len(matches))
+}
+
+//export editGameFiles
+func editGameFiles(utocFile *C.char, ucasFile *C.char, outFile *C.char, editsFile *C.char, compressionMethod *C.char, AESKey *C.char) C.int {
+	utocFname := C.GoString(utocFile)
+	ucasFname := C.GoString(ucasFile)
+	outPath := C.GoString(outFile)
+	outPath = strings.TrimSuffix(outPath, filepath.Ext(outPath)) // remove any extension
+	compression := ""
+	if compressionMethod != nil {
+		compression = C.GoString(compressionMethod)
+	}
+	keys, err := convertKeyring(AESKey)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
 
+	edits, err := readContainerEdits(C.GoString(editsFile))
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	d, err := parseUtocFile(utocFname, keys)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	if d.hdr.isEncrypted() {
+		staticErr = "editing encrypted containers is not supported"
+		return C.int(-1)
+	}
+	editor, err := openContainerEditor(d, ucasFname)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	err = editor.apply(edits)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	n, err := editor.Save(outPath, compression)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	return C.int(n - 1) // correction for dependencies file
+}
+
+//export transcodeGameFiles
+func transcodeGameFiles(utocFile *C.char, ucasFile *C.char, outFile *C.char, compressionMethod *C.char, blockSize C.int, blockAlignment C.int, AESKey *C.char) C.int {
+	utocFname := C.GoString(utocFile)
+	ucasFname := C.GoString(ucasFile)
+	outPath := C.GoString(outFile)
+	outPath = strings.TrimSuffix(outPath, filepath.Ext(outPath)) // remove any extension
+	compression := "None"
+	if compressionMethod != nil {
+		compression = C.GoString(compressionMethod)
+	}
+	if blockSize < 0 || blockAlignment < 0 {
+		staticErr = "block size and alignment can not be negative"
+		return C.int(-1)
+	}
+	keys, err := convertKeyring(AESKey)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+
+	d, err := parseUtocFile(utocFname, keys)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	if d.hdr.isEncrypted() {
+		ucasFname, err = d.decryptUcasToTempFile(ucasFname)
+		if err != nil {
+			staticErr = err.Error()
+			return C.int(-1)
+		}
+		defer os.Remove(ucasFname)
+	}
+	n, err := transcodeContainer(d, ucasFname, outPath, compression, uint32(blockSize), uint32(blockAlignment))
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	return C.int(n - 1) // correction for dependencies file
+}
+
+//export setOodleLibraryPath
+func setOodleLibraryPath(libPath *C.char) C.int {
+	path := ""
+	if libPath != nil {
+		path = C.GoString(libPath)
+	}
+	if err := setOodleLibrary(path); err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	return C.int(0)
+}
+
+//export verifyGameFiles
+func verifyGameFiles(utocFile *C.char, ucasFile *C.char, publicKey *C.char, AESKey *C.char) C.int {
+	utocFname := C.GoString(utocFile)
+	ucasFname := C.GoString(ucasFile)
+	keys, err := convertKeyring(AESKey)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+
+	d, err := parseUtocFile(utocFname, keys)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	// the hashes are of the blocks as they are stored, so an encrypted .ucas file is not decrypted
+	err = d.verifyBlockHashes(ucasFname)
+	if err == nil && publicKey != nil {
+		var key *rsa.PublicKey
+		key, err = readRSAPublicKey(C.GoString(publicKey))
+		if err == nil {
+			err = d.verifySignature(key)
+		}
+	}
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	return C.int(0)
+}
+
+//export unpackGameChunk
+func unpackGameChunk(utocFile *C.char, ucasFile *C.char, chunkID *C.char, outputFile *C.char, AESKey *C.char) C.int {
+	utocFname := C.GoString(utocFile)
+	ucasFname := C.GoString(ucasFile)
+	id := C.GoString(chunkID)
+	outFname := C.GoString(outputFile)
+	keys, err := convertKeyring(AESKey)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	if len(id) != 24 {
+		staticErr = "a chunk ID of 24 hexadecimal characters is required"
+		return C.int(-1)
+	}
+
+	d, err := parseUtocFile(utocFname, keys)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
+	if d.hdr.isEncrypted() {
+		ucasFname, err = d.decryptUcasToTempFile(ucasFname)
+		if err != nil {
+			staticErr = err.Error()
+			return C.int(-1)
+		}
+		defer os.Remove(ucasFname)
+	}
+	err = d.unpackChunk(ucasFname, FromHexString(id), outFname)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
 	return C.int(0)
 }
 
@@ -167,9 +796,13 @@ func unpackGameFiles(utocFile *C.char, ucasFile *C.char, outputDirectory *C.char
 	ucasFname := C.GoString(ucasFile)
 	outDir := C.GoString(outputDirectory)
 	reg := C.GoString(regex)
-	aes := convertAES(AESKey)
+	keys, err := convertKeyring(AESKey)
+	if err != nil {
+		staticErr = err.Error()
+		return C.int(-1)
+	}
 
-	d, err := parseUtocFile(utocFname, aes)
+	d, err := parseUtocFile(utocFname, keys)
 	if err != nil {
 		staticErr = err.Error()
 		return C.int(-1)
@@ -177,29 +810,12 @@ func unpackGameFiles(utocFile *C.char, ucasFile *C.char, outputDirectory *C.char
 	// ucas may also be encrypted; create temporary file and place decrypted version there
 	// let the ucasreader read from the temporary file
 	if d.hdr.isEncrypted() {
-		tmpFile, err := os.CreateTemp("", "tmp")
-		if err != nil {
-			staticErr = err.Error()
-			return C.int(-1)
-		}
-		ucasBytes, err := ioutil.ReadFile(ucasFname)
-		if err != nil {
-			staticErr = err.Error()
-			return C.int(-1)
-		}
-		decryptedBytes, err := decryptAES(&ucasBytes, aes)
+		ucasFname, err = d.decryptUcasToTempFile(ucasFname)
 		if err != nil {
 			staticErr = err.Error()
 			return C.int(-1)
 		}
-		tmpFile.Write(*decryptedBytes)
-		ucasFname = tmpFile.Name()
-		err = tmpFile.Close()
-		if err != nil {
-			fmt.Println("err:", err)
-			return C.int(-1)
-		}
-		defer os.Remove(tmpFile.Name())
+		defer os.Remove(ucasFname)
 	}
 
 	// we need the parsed .utoc file to unpack the files that are included in the .ucas file.
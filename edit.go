@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The ContainerEditor changes the files of an existing container, without unpacking and repacking everything.
+// Edits are collected first; Save writes the container again, where the compressed blocks of all
+// untouched files are copied byte for byte. Only new and replaced data is compressed.
+// The .utoc file, including the directory index and hashes, and the container header are regenerated.
+// Paths are below the root of the game ("/Game/Content/..."), like the unpacked files, regardless of the mount point;
+// the mount point of the container is kept, so new paths must be below it.
+
+const (
+	EditAdd     = "add"
+	EditReplace = "replace"
+	EditRemove  = "remove"
+	EditRename  = "rename"
+)
+
+// ContainerEdit describes one edit, so that a list of edits can be read from a JSON file.
+type ContainerEdit struct {
+	Operation string `json:"op"`
+	Path      string `json:"path"`              // path of the file; a chunk ID in hex is accepted as well
+	NewPath   string `json:"newPath,omitempty"` // only for rename
+	ChunkID   string `json:"chunkId,omitempty"` // only for add
+	Source    string `json:"source,omitempty"`  // file with the new data, for add and replace
+}
+
+type addedFile struct {
+	fpath   string // relative to the mount point
+	chunkID FIoChunkID
+	data    []byte
+}
+
+type ContainerEditor struct {
+	toc      *UTocData
+	ucasPath string // must not be encrypted
+	deps     *Dependencies
+	replaced map[int][]byte // index in toc.files to new data
+	removed  map[int]bool
+	renamed  map[int]string
+	added    []addedFile
+}
+
+// containers without a container header, such as global.utoc, can be edited as well; they don't get one.
+func openContainerEditor(toc *UTocData, ucasPath string) (*ContainerEditor, error) {
+	if err := toc.checkRewritable(); err != nil {
+		return nil, err
+	}
+	deps := &Dependencies{ThisPackageID: uint64(toc.hdr.ContainerID), ChunkIDToDependencies: make(map[uint64]FileDependency)}
+	if toc.hasDependencies() {
+		data, err := toc.unpackDependencies(ucasPath)
+		if err != nil {
+			return nil, err
+		}
+		deps, err = ParseDependencies(*data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &ContainerEditor{
+		toc:      toc,
+		ucasPath: ucasPath,
+		deps:     deps,
+		replaced: make(map[int][]byte),
+		removed:  make(map[int]bool),
+		renamed:  make(map[int]string),
+	}, nil
+}
+
+// containerPath returns the path of a file relative to the mount point of the container
+func (e *ContainerEditor) containerPath(fpath string) (string, error) {
+	return rebaseMountPath(fpath, MountPoint, MountPoint+e.toc.mountPoint)
+}
+
+// finds the index of a file by its (new) path or by its chunk ID in hexadecimal format
+func (e *ContainerEditor) findFile(key string) (int, error) {
+	keyPath, err := e.containerPath(key)
+	if err != nil {
+		keyPath = "" // not below the mount point, so it can only be a chunk ID
+	}
+	for i, f := range e.toc.files {
+		if f.filepath == DepFileName || e.removed[i] {
+			continue
+		}
+		fpath := f.filepath
+		if newPath, ok := e.renamed[i]; ok {
+			fpath = newPath
+		}
+		if (fpath != "" && fpath == keyPath) || strings.EqualFold(f.chunkID.ToHexString(), key) {
+			return i, nil
+		}
+	}
+	return -1, errors.New("file not found in container: " + key)
+}
+
+func (e *ContainerEditor) pathExists(fpath string) bool {
+	if _, err := e.findFile(fpath); err == nil {
+		return true
+	}
+	keyPath, _ := e.containerPath(fpath)
+	for _, a := range e.added {
+		if a.fpath == keyPath {
+			return true
+		}
+	}
+	return false
+}
+
+// AddFile adds a new file to the container. If the file is the ExportBundleData of a package that is
+// not in the container header yet, an entry without dependencies is created for it; other chunks,
+// such as bulk data, don't have an entry of their own.
+func (e *ContainerEditor) AddFile(fpath string, chunkID FIoChunkID, data []byte) error {
+	if e.pathExists(fpath) {
+		return errors.New("file already exists in container: " + fpath)
+	}
+	containerPath, err := e.containerPath(fpath)
+	if err != nil {
+		return err
+	}
+	for i, f := range e.toc.files {
+		if f.chunkID == chunkID && !e.removed[i] {
+			return errors.New("chunk ID already exists in container: " + chunkID.ToHexString())
+		}
+	}
+	for _, a := range e.added {
+		if a.chunkID == chunkID {
+			return errors.New("chunk ID already exists in container: " + chunkID.ToHexString())
+		}
+	}
+	e.added = append(e.added, addedFile{fpath: containerPath, chunkID: chunkID, data: data})
+	if _, ok := e.deps.ChunkIDToDependencies[chunkID.ID]; !ok && chunkID.Type == ExportBundleDataChunkType {
+		e.deps.ChunkIDToDependencies[chunkID.ID] = FileDependency{FileSize: uint64(len(data))}
+	}
+	return nil
+}
+
+// ReplaceFile replaces the data of a file.
+// The uncompressed size in the container header is updated, if it described this file.
+func (e *ContainerEditor) ReplaceFile(key string, data []byte) error {
+	i, err := e.findFile(key)
+	if err != nil {
+		return err
+	}
+	e.replaced[i] = data
+	id := e.toc.files[i].chunkID.ID
+	if dep, ok := e.deps.ChunkIDToDependencies[id]; ok && dep.FileSize == e.toc.files[i].offlen.GetLength() {
+		dep.FileSize = uint64(len(data))
+		e.deps.ChunkIDToDependencies[id] = dep
+	}
+	return nil
+}
+
+// RemoveFile removes a file. The package is removed from the container header when none of its chunks are left.
+func (e *ContainerEditor) RemoveFile(key string) error {
+	i, err := e.findFile(key)
+	if err != nil {
+		return err
+	}
+	e.removed[i] = true
+	delete(e.replaced, i)
+	delete(e.renamed, i)
+
+	id := e.toc.files[i].chunkID.ID
+	for j, f := range e.toc.files {
+		if f.chunkID.ID == id && !e.removed[j] && f.filepath != DepFileName {
+			return nil
+		}
+	}
+	for _, a := range e.added {
+		if a.chunkID.ID == id {
+			return nil
+		}
+	}
+	delete(e.deps.ChunkIDToDependencies, id)
+	return nil
+}
+
+// RenameFile changes the path of a file, the chunk ID stays the same.
+func (e *ContainerEditor) RenameFile(key string, newPath string) error {
+	i, err := e.findFile(key)
+	if err != nil {
+		return err
+	}
+	if e.pathExists(newPath) {
+		return errors.New("file already exists in container: " + newPath)
+	}
+	containerPath, err := e.containerPath(newPath)
+	if err != nil {
+		return err
+	}
+	e.renamed[i] = containerPath
+	return nil
+}
+
+// applies a list of edits in order; the data of added and replaced files is read from their source file.
+func (e *ContainerEditor) apply(edits []ContainerEdit) error {
+	for _, edit := range edits {
+		var err error
+		switch strings.ToLower(edit.Operation) {
+		case EditAdd:
+			var data []byte
+			data, err = os.ReadFile(edit.Source)
+			if err == nil {
+				if len(edit.ChunkID) != 24 {
+					return errors.New("a chunk ID of 24 hexadecimal characters is required to add " + edit.Path)
+				}
+				err = e.AddFile(edit.Path, FromHexString(edit.ChunkID), data)
+			}
+		case EditReplace:
+			var data []byte
+			data, err = os.ReadFile(edit.Source)
+			if err == nil {
+				err = e.ReplaceFile(edit.Path, data)
+			}
+		case EditRemove:
+			err = e.RemoveFile(edit.Path)
+		case EditRename:
+			err = e.RenameFile(edit.Path, edit.NewPath)
+		default:
+			err = errors.New("unknown edit operation " + edit.Operation)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readContainerEdits(editsPath string) ([]ContainerEdit, error) {
+	b, err := os.ReadFile(editsPath)
+	if err != nil {
+		return nil, err
+	}
+	var edits []ContainerEdit
+	err = json.Unmarshal(b, &edits)
+	return edits, err
+}
+
+// Save writes the edited container to outFilename{.utoc, .ucas}, which may be the container that is edited.
+// New and replaced data is compressed with the given method; if empty, the first method of the container is used.
+func (e *ContainerEditor) Save(outFilename string, compression string) (int, error) {
+	if compression == "" {
+		compression = e.toc.compressionMethods[0]
+		if len(e.toc.compressionMethods) > 1 {
+			compression = e.toc.compressionMethods[1]
+		}
+	}
+	// write to temporary files first, the original files are still read
+	tmpFilename := outFilename + ".tmp"
+	w, err := newContainerWriter(tmpFilename, e.toc.hdr.CompressionBlockSize)
+	if err != nil {
+		return 0, err
+	}
+	w.mountPoint = MountPoint + e.toc.mountPoint
+	w.containerID = e.toc.hdr.ContainerID
+	ucas, err := os.Open(e.ucasPath)
+	if err != nil {
+		w.abort()
+		return 0, err
+	}
+	for i, f := range e.toc.files {
+		if f.filepath == DepFileName || e.removed[i] {
+			continue
+		}
+		if newPath, ok := e.renamed[i]; ok {
+			f.filepath = newPath
+		}
+		if data, ok := e.replaced[i]; ok {
+			err = w.writeChunk(f.filepath, f.chunkID, data, compression)
+		} else {
+			err = w.copyChunk(e.toc, ucas, &f)
+		}
+		if err != nil {
+			ucas.Close()
+			w.abort()
+			return 0, fmt.Errorf("could not write %s: %w", f.name(), err)
+		}
+	}
+	ucas.Close()
+	for _, a := range e.added {
+		err = w.writeChunk(a.fpath, a.chunkID, a.data, compression)
+		if err != nil {
+			w.abort()
+			return 0, fmt.Errorf("could not write %s: %w", a.fpath, err)
+		}
+	}
+	if e.toc.hasDependencies() {
+		err = w.writeDependencies(e.deps)
+		if err != nil {
+			w.abort()
+			return 0, err
+		}
+	}
+	err = w.finish(tmpFilename, nil)
+	if err != nil {
+		return 0, err
+	}
+	for _, ext := range []string{".ucas", ".utoc"} {
+		err = os.Rename(tmpFilename+ext, outFilename+ext)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(w.files), nil
+}
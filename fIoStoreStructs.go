@@ -6,6 +6,12 @@ import (
 	"strconv"
 )
 
+// the largest values that fit in the fields of FIoOffsetAndLength (5 bytes) and FIoStoreTocCompressedBlockEntry (5 and 3 bytes)
+const (
+	MaxOffsetOrLength = 1<<40 - 1
+	MaxBlockSize      = 1<<24 - 1
+)
+
 type FIoContainerID uint64
 type FIoStoreTocEntryMetaFlags uint8
 type FString string // just a string I guess? But fancier...
@@ -38,10 +44,11 @@ type FIoChunkID struct {
 }
 
 type DirIndexWrapper struct {
-	dirs     *[]*FIoDirectoryIndexEntry
-	files    *[]*FIoFileIndexEntry
-	strTable *map[string]int
-	strSlice *[]string
+	mountPoint string
+	dirs       *[]*FIoDirectoryIndexEntry
+	files      *[]*FIoFileIndexEntry
+	strTable   *map[string]int
+	strSlice   *[]string
 }
 
 func (r *FIoDirectoryIndexEntry) AddFile(fpathSections []string, fIndex uint32, structure *DirIndexWrapper) {
@@ -171,19 +178,27 @@ func (f *FIoOffsetAndLength) GetLength() uint64 {
 		(uint64(f.Length[1]) << 24) |
 		(uint64(f.Length[0]) << 32)
 }
-func (f *FIoOffsetAndLength) SetOffset(offset uint64) {
+func (f *FIoOffsetAndLength) SetOffset(offset uint64) error {
+	if offset > MaxOffsetOrLength {
+		return fmt.Errorf("offset %#x does not fit in 40 bits", offset)
+	}
 	f.Offset[0] = uint8(offset >> 32)
 	f.Offset[1] = uint8(offset >> 24)
 	f.Offset[2] = uint8(offset >> 16)
 	f.Offset[3] = uint8(offset >> 8)
 	f.Offset[4] = uint8(offset >> 0)
+	return nil
 }
-func (f *FIoOffsetAndLength) SetLength(length uint64) {
+func (f *FIoOffsetAndLength) SetLength(length uint64) error {
+	if length > MaxOffsetOrLength {
+		return fmt.Errorf("length %#x does not fit in 40 bits", length)
+	}
 	f.Length[0] = uint8(length >> 32)
 	f.Length[1] = uint8(length >> 24)
 	f.Length[2] = uint8(length >> 16)
 	f.Length[3] = uint8(length >> 8)
 	f.Length[4] = uint8(length >> 0)
+	return nil
 }
 
 func (f *FIoStoreTocCompressedBlockEntry) GetOffset() uint64 {
@@ -197,20 +212,32 @@ func (f *FIoStoreTocCompressedBlockEntry) GetUncompressedSize() uint32 {
 	return binary.LittleEndian.Uint32(normalize(f.UncompressedSize[:]))
 }
 
-func (f *FIoStoreTocCompressedBlockEntry) SetOffset(offset uint64) {
+func (f *FIoStoreTocCompressedBlockEntry) SetOffset(offset uint64) error {
+	if offset > MaxOffsetOrLength {
+		return fmt.Errorf("compression block offset %#x does not fit in 40 bits", offset)
+	}
 	r := make([]byte, 5)
 	for i := uint64(0); i < 5; i++ {
 		r[i] = byte((offset >> (i * 8)) & 0xff)
 	}
 	copy(f.Offset[:], r)
+	return nil
 }
-func (f *FIoStoreTocCompressedBlockEntry) SetUncompressedSize(size uint32) {
+func (f *FIoStoreTocCompressedBlockEntry) SetUncompressedSize(size uint32) error {
+	if size > MaxBlockSize {
+		return fmt.Errorf("uncompressed block size %#x does not fit in 24 bits", size)
+	}
 	f.UncompressedSize[0] = uint8(size >> 0)
 	f.UncompressedSize[1] = uint8(size >> 8)
 	f.UncompressedSize[2] = uint8(size >> 16)
+	return nil
 }
-func (f *FIoStoreTocCompressedBlockEntry) SetCompressedSize(size uint32) {
+func (f *FIoStoreTocCompressedBlockEntry) SetCompressedSize(size uint32) error {
+	if size > MaxBlockSize {
+		return fmt.Errorf("compressed block size %#x does not fit in 24 bits", size)
+	}
 	f.CompressedSize[0] = uint8(size >> 0)
 	f.CompressedSize[1] = uint8(size >> 8)
 	f.CompressedSize[2] = uint8(size >> 16)
+	return nil
 }
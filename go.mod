@@ -3,9 +3,11 @@ module github.com/gitMenv/UEcastoc
 go 1.18
 
 require (
+	github.com/klauspost/compress v1.16.7
 	github.com/new-world-tools/go-oodle v0.1.2
 	github.com/pierrec/lz4 v2.6.1+incompatible
 	github.com/pierrec/lz4/v4 v4.1.17
+	github.com/ulikunitz/xz v0.5.15
 )
 
 require (
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The container header lists the packages that every package imports. A dependency graph turns this list
+// into forward edges (the packages that a package depends on) and reverse edges (the packages that depend on it),
+// so it is possible to see which packages break when a package is changed.
+// Packages are identified by their package ID, which is the ID of their chunks; the path of a package is that
+// of its ExportBundleData chunk. Packages in other containers, such as those of the engine, have no path.
+
+const (
+	GraphFormatDOT     = "dot"
+	GraphFormatGraphML = "graphml"
+	GraphFormatJSON    = "json"
+)
+
+type DependencyGraph struct {
+	paths      map[uint64]string
+	forward    map[uint64][]uint64
+	reverse    map[uint64][]uint64
+	inManifest map[uint64]bool // packages with an entry in the dependencies
+}
+
+// NewDependencyGraph creates the graph of the dependencies; paths maps package IDs to their path
+func NewDependencyGraph(deps *Dependencies, paths map[uint64]string) *DependencyGraph {
+	g := &DependencyGraph{
+		paths:      paths,
+		forward:    make(map[uint64][]uint64),
+		reverse:    make(map[uint64][]uint64),
+		inManifest: make(map[uint64]bool),
+	}
+	for id, dep := range deps.ChunkIDToDependencies {
+		g.inManifest[id] = true
+		g.forward[id] = append([]uint64{}, dep.Dependencies...)
+		for _, d := range dep.Dependencies {
+			g.reverse[d] = append(g.reverse[d], id)
+		}
+	}
+	for _, ids := range []map[uint64][]uint64{g.forward, g.reverse} {
+		for id := range ids {
+			sortPackageIDs(ids[id])
+		}
+	}
+	return g
+}
+
+func sortPackageIDs(ids []uint64) {
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+}
+
+// packagePaths maps the package IDs to the paths below the root of the game; the path of the ExportBundleData
+// chunk is preferred over that of other chunks of the package
+func (u *UTocData) packagePaths() map[uint64]string {
+	paths := make(map[uint64]string)
+	for i, f := range u.files {
+		fpath := u.gamePath(&u.files[i])
+		if fpath == "" {
+			continue
+		}
+		if _, ok := paths[f.chunkID.ID]; !ok || f.chunkID.Type == ExportBundleDataChunkType {
+			paths[f.chunkID.ID] = fpath
+		}
+	}
+	return paths
+}
+
+// dependencyGraph reads the dependencies of the container; a container without dependencies has an empty graph
+func (u *UTocData) dependencyGraph(ucasPath string) (*DependencyGraph, error) {
+	deps := &Dependencies{ChunkIDToDependencies: make(map[uint64]FileDependency)}
+	if u.hasDependencies() {
+		data, err := u.unpackDependencies(ucasPath)
+		if err != nil {
+			return nil, err
+		}
+		deps, err = ParseDependencies(*data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewDependencyGraph(deps, u.packagePaths()), nil
+}
+
+// readDependencyGraph reads the dependency graph of a container; an encrypted container is decrypted with the keys
+func readDependencyGraph(utocPath string, ucasPath string, keys *Keyring) (*DependencyGraph, error) {
+	u, err := parseUtocFile(utocPath, keys)
+	if err != nil {
+		return nil, err
+	}
+	if u.hdr.isEncrypted() {
+		ucasPath, err = u.decryptUcasToTempFile(ucasPath)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(ucasPath)
+	}
+	return u.dependencyGraph(ucasPath)
+}
+
+// DependencyGraph creates the graph of the dependencies in the manifest
+func (m *Manifest) DependencyGraph() (*DependencyGraph, error) {
+	paths := make(map[uint64]string)
+	for i, f := range m.Files {
+		if f.Filepath == DepFileName {
+			continue
+		}
+		chunkID, err := parseChunkID(f.ChunkID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Filepath, err)
+		}
+		fpath, err := m.gamePath(&m.Files[i])
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := paths[chunkID.ID]; !ok || chunkID.Type == ExportBundleDataChunkType {
+			paths[chunkID.ID] = fpath
+		}
+	}
+	return NewDependencyGraph(&m.Deps, paths), nil
+}
+
+// Packages returns all packages in the graph in order, including those that are only depended on
+func (g *DependencyGraph) Packages() []uint64 {
+	ids := []uint64{}
+	for id := range g.inManifest {
+		ids = append(ids, id)
+	}
+	for id := range g.reverse {
+		if !g.inManifest[id] {
+			ids = append(ids, id)
+		}
+	}
+	sortPackageIDs(ids)
+	return ids
+}
+
+// Path returns the path of the package, or its package ID in hexadecimal format if it has no path
+func (g *DependencyGraph) Path(id uint64) string {
+	if p, ok := g.paths[id]; ok {
+		return p
+	}
+	return fmt.Sprintf("%016x", id)
+}
+
+// Find returns the package ID of a package by its path below the root of the game, its chunk ID,
+// or its package ID in hexadecimal format
+func (g *DependencyGraph) Find(key string) (uint64, error) {
+	fpath := "/" + strings.TrimPrefix(strings.ReplaceAll(key, "\\", "/"), "/")
+	for id, p := range g.paths {
+		if strings.EqualFold(p, fpath) {
+			return id, nil
+		}
+	}
+	if chunkID, err := parseChunkID(key); err == nil {
+		key = fmt.Sprintf("%016x", chunkID.ID)
+	}
+	if id, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(key), "0x"), 16, 64); err == nil {
+		if _, ok := g.reverse[id]; ok || g.inManifest[id] {
+			return id, nil
+		}
+	}
+	return 0, errors.New("package not found: " + key)
+}
+
+// Dependencies returns the packages that the package imports
+func (g *DependencyGraph) Dependencies(id uint64) []uint64 {
+	return g.forward[id]
+}
+
+// Dependents returns the packages that import the package
+func (g *DependencyGraph) Dependents(id uint64) []uint64 {
+	return g.reverse[id]
+}
+
+// closure returns all packages that can be reached from the package, without the package itself
+func closure(edges map[uint64][]uint64, id uint64) []uint64 {
+	visited := map[uint64]bool{id: true}
+	queue := []uint64{id}
+	reached := []uint64{}
+	for len(queue) != 0 {
+		next := queue[0]
+		queue = queue[1:]
+		for _, dep := range edges[next] {
+			if visited[dep] {
+				continue
+			}
+			visited[dep] = true
+			queue = append(queue, dep)
+			reached = append(reached, dep)
+		}
+	}
+	sortPackageIDs(reached)
+	return reached
+}
+
+// TransitiveDependencies returns all packages that the package needs, directly or through other packages
+func (g *DependencyGraph) TransitiveDependencies(id uint64) []uint64 {
+	return closure(g.forward, id)
+}
+
+// TransitiveDependents returns all packages that need the package, directly or through other packages;
+// these are the packages that may break when the package is changed
+func (g *DependencyGraph) TransitiveDependents(id uint64) []uint64 {
+	return closure(g.reverse, id)
+}
+
+// Cycles returns the groups of packages that depend on each other, in order of their smallest package ID.
+// Each group is a strongly connected component of the graph; a package that imports itself is a group as well.
+func (g *DependencyGraph) Cycles() [][]uint64 {
+	// Tarjan's algorithm
+	index := make(map[uint64]int)
+	lowlink := make(map[uint64]int)
+	onStack := make(map[uint64]bool)
+	var stack []uint64
+	var cycles [][]uint64
+	var visit func(id uint64)
+	visit = func(id uint64) {
+		index[id] = len(index)
+		lowlink[id] = index[id]
+		stack = append(stack, id)
+		onStack[id] = true
+		selfLoop := false
+		for _, dep := range g.forward[id] {
+			if dep == id {
+				selfLoop = true
+			}
+			if _, ok := index[dep]; !ok {
+				visit(dep)
+				if lowlink[dep] < lowlink[id] {
+					lowlink[id] = lowlink[dep]
+				}
+			} else if onStack[dep] && index[dep] < lowlink[id] {
+				lowlink[id] = index[dep]
+			}
+		}
+		if lowlink[id] != index[id] {
+			return
+		}
+		var component []uint64
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			component = append(component, top)
+			if top == id {
+				break
+			}
+		}
+		if len(component) > 1 || selfLoop {
+			sortPackageIDs(component)
+			cycles = append(cycles, component)
+		}
+	}
+	for _, id := range g.Packages() {
+		if _, ok := index[id]; !ok {
+			visit(id)
+		}
+	}
+	sort.Slice(cycles, func(i, j int) bool {
+		return cycles[i][0] < cycles[j][0]
+	})
+	return cycles
+}
+
+// Subgraph returns the graph of the given packages and the edges between them
+func (g *DependencyGraph) Subgraph(ids []uint64) *DependencyGraph {
+	keep := make(map[uint64]bool)
+	for _, id := range ids {
+		keep[id] = true
+	}
+	sub := &DependencyGraph{
+		paths:      g.paths,
+		forward:    make(map[uint64][]uint64),
+		reverse:    make(map[uint64][]uint64),
+		inManifest: make(map[uint64]bool),
+	}
+	for id := range keep {
+		sub.reverse[id] = []uint64{}
+		if g.inManifest[id] {
+			sub.inManifest[id] = true
+			sub.forward[id] = []uint64{}
+		}
+		for _, dep := range g.forward[id] {
+			if keep[dep] {
+				sub.forward[id] = append(sub.forward[id], dep)
+			}
+		}
+		for _, dep := range g.reverse[id] {
+			if keep[dep] {
+				sub.reverse[id] = append(sub.reverse[id], dep)
+			}
+		}
+	}
+	return sub
+}
+
+// Neighbourhood returns the graph of the package with all packages it needs and all packages that need it
+func (g *DependencyGraph) Neighbourhood(id uint64) *DependencyGraph {
+	ids := append([]uint64{id}, g.TransitiveDependencies(id)...)
+	return g.Subgraph(append(ids, g.TransitiveDependents(id)...))
+}
+
+// packageNodeID is the name of a package in DOT and GraphML
+func packageNodeID(id uint64) string {
+	return fmt.Sprintf("p%016x", id)
+}
+
+// ToDOT formats the graph for Graphviz; an edge points from a package to the package it depends on.
+// Packages in other containers are drawn dashed.
+func (g *DependencyGraph) ToDOT() string {
+	var sb strings.Builder
+	sb.WriteString("digraph dependencies {\n")
+	sb.WriteString("  node [shape=box];\n")
+	for _, id := range g.Packages() {
+		style := ""
+		if !g.inManifest[id] {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(&sb, "  %s [label=%s%s];\n", packageNodeID(id), strconv.Quote(g.Path(id)), style)
+	}
+	for _, id := range g.Packages() {
+		for _, dep := range g.forward[id] {
+			fmt.Fprintf(&sb, "  %s -> %s;\n", packageNodeID(id), packageNodeID(dep))
+		}
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+}
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   struct {
+		ID          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+// ToGraphML formats the graph as GraphML, which can be opened with tools such as yEd, Gephi and networkx.
+// Every node has the package ID, the path, and whether the package is in this container.
+func (g *DependencyGraph) ToGraphML() ([]byte, error) {
+	doc := graphML{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{ID: "packageId", For: "node", Name: "packageId", Type: "string"},
+			{ID: "path", For: "node", Name: "path", Type: "string"},
+			{ID: "internal", For: "node", Name: "internal", Type: "boolean"},
+		},
+	}
+	doc.Graph.ID = "dependencies"
+	doc.Graph.EdgeDefault = "directed"
+	for _, id := range g.Packages() {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			ID: packageNodeID(id),
+			Data: []graphMLData{
+				{Key: "packageId", Value: fmt.Sprintf("%016x", id)},
+				{Key: "path", Value: g.paths[id]},
+				{Key: "internal", Value: strconv.FormatBool(g.inManifest[id])},
+			},
+		})
+		for _, dep := range g.forward[id] {
+			doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{Source: packageNodeID(id), Target: packageNodeID(dep)})
+		}
+	}
+	b, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(b, '\n')...), nil
+}
+
+// package IDs are in hexadecimal format, like in the manifest, as JSON numbers can't hold all 64 bits
+type PackageNode struct {
+	PackageID    string   `json:"packageId"`
+	Path         string   `json:"path,omitempty"`
+	Internal     bool     `json:"internal"` // whether the package has an entry in the dependencies
+	Dependencies []string `json:"dependencies"`
+	Dependents   []string `json:"dependents"`
+}
+
+func packageIDStrings(ids []uint64) []string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = fmt.Sprintf("%016x", id)
+	}
+	return s
+}
+
+// ToJSON formats the graph as a list of packages with their dependencies and dependents, followed by the cycles
+func (g *DependencyGraph) ToJSON() ([]byte, error) {
+	out := struct {
+		Packages []PackageNode `json:"packages"`
+		Cycles   [][]string    `json:"cycles"`
+	}{Packages: []PackageNode{}, Cycles: [][]string{}}
+	for _, cycle := range g.Cycles() {
+		out.Cycles = append(out.Cycles, packageIDStrings(cycle))
+	}
+	for _, id := range g.Packages() {
+		node := PackageNode{
+			PackageID:    fmt.Sprintf("%016x", id),
+			Path:         g.paths[id],
+			Internal:     g.inManifest[id],
+			Dependencies: packageIDStrings(g.forward[id]),
+			Dependents:   packageIDStrings(g.reverse[id]),
+		}
+		out.Packages = append(out.Packages, node)
+	}
+	return json.MarshalIndent(out, "", "  ")
+}
+
+// Format formats the graph as dot, graphml or json
+func (g *DependencyGraph) Format(format string) ([]byte, error) {
+	switch strings.ToLower(format) {
+	case GraphFormatDOT, "":
+		return []byte(g.ToDOT()), nil
+	case GraphFormatGraphML:
+		return g.ToGraphML()
+	case GraphFormatJSON:
+		return g.ToJSON()
+	default:
+		return nil, errors.New("unknown graph format " + format + "; use dot, graphml or json")
+	}
+}
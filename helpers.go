@@ -2,10 +2,10 @@ package main
 
 import (
 	"crypto/aes"
-	"crypto/rand"
 	"crypto/sha1"
 	"encoding/binary"
-	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"strings"
 	"unsafe"
 )
@@ -23,17 +23,91 @@ func strSliceToC(list *[]string) **C.char {
 	return (**C.char)(strs)
 }
 
-func convertAES(AES *C.char) []byte {
+// the reverse of strSliceToC; the strings are copied, so the caller keeps ownership of the list
+func cStrSliceToGo(list **C.char, n C.int) []string {
+	strlist := []string{}
+	for i := 0; i < int(n); i++ {
+		str := *(**C.char)(unsafe.Pointer(uintptr(unsafe.Pointer(list)) + uintptr(i)*unsafe.Sizeof(*list)))
+		strlist = append(strlist, C.GoString(str))
+	}
+	return strlist
+}
+
+// the AES key argument is a single key or the path of a keyring file, see ParseKeyring
+func convertKeyring(AES *C.char) (*Keyring, error) {
 	s := ""
 	if AES != nil {
 		s = C.GoString(AES)
 	}
-	// go string to []byte
-	s = strings.TrimPrefix(s, "0x")
-	s = strings.TrimPrefix(s, "0X")
+	return ParseKeyring(s)
+}
 
-	b, _ := hex.DecodeString(s)
-	return b
+// PackOptionsJSON are the options of packGameFilesWithOptions, passed as a JSON object.
+// The fields are those of PackOptions, except that AESKey is a key or the path of a keyring file
+// and AESKeyGuid is a GUID string; fields that are left out keep their default.
+type PackOptionsJSON struct {
+	Compression   string
+	BlockSize     uint32
+	Order         string
+	FileAlignment uint32
+	Deduplicate   bool
+	CacheDir      string
+	MountPoint    string
+	SigningKey    string
+	Discover      bool
+	Subset        bool
+	AESKey        string
+	AESKeyGuid    string
+}
+
+// the options argument is a JSON object with PackOptionsJSON; unknown fields are an error, so that a typo isn't ignored
+func convertPackOptions(options *C.char) (PackOptionsJSON, error) {
+	o := PackOptionsJSON{}
+	if options == nil {
+		return o, nil
+	}
+	dec := json.NewDecoder(strings.NewReader(C.GoString(options)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&o); err != nil {
+		return o, errors.New("invalid pack options: " + err.Error())
+	}
+	return o, nil
+}
+
+// the PackOptions for packing; the key that encrypts the container is taken from the keyring by its GUID,
+// which is defaultGuid unless another one is set, and there is no key when the keyring is empty
+func (o PackOptionsJSON) packOptions(defaultGuid FGuid) (PackOptions, error) {
+	opts := PackOptions{
+		Compression:   o.Compression,
+		BlockSize:     o.BlockSize,
+		Order:         o.Order,
+		FileAlignment: o.FileAlignment,
+		Deduplicate:   o.Deduplicate,
+		CacheDir:      o.CacheDir,
+		MountPoint:    o.MountPoint,
+		SigningKey:    o.SigningKey,
+		Discover:      o.Discover,
+		Subset:        o.Subset,
+		AESKeyGuid:    defaultGuid,
+	}
+	if opts.Compression == "" {
+		opts.Compression = "None"
+	}
+	keys, err := ParseKeyring(o.AESKey)
+	if err != nil {
+		return opts, err
+	}
+	if o.AESKeyGuid != "" {
+		opts.AESKeyGuid, err = parseGuid(o.AESKeyGuid)
+		if err != nil {
+			return opts, err
+		}
+	}
+	if len(keys.keys) == 0 && keys.anyKey == nil {
+		return opts, nil
+	}
+	opts.AESKey, err = keys.keyFor(opts.AESKeyGuid)
+	return opts, err
 }
 
 func decryptAES(ciphertext *[]byte, AES []byte) (*[]byte, error) {
@@ -70,12 +144,6 @@ func sha1Hash(fdata *[]byte) *FIoChunkHash {
 	return &hash
 }
 
-func getRandomBytes(n int) []byte {
-	ret := make([]byte, n)
-	rand.Read(ret)
-	return ret
-}
-
 // A string must have a preamble of the strlen and a nullbyte at the end.
 // this function returns the string in the "FString" format.
 func stringToFString(str string) []byte {
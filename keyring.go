@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// A keyring holds the AES keys of several containers. Every encrypted container names the key it was encrypted
+// with by the EncryptionKeyGuid in its header; the main key of a game has the zero GUID.
+// Wherever an AES key is passed, either a single key or the path to a keyring file can be used.
+// A key is written in hexadecimal format (optionally starting with 0x) or in base64, like in the Crypto.json of a project.
+// A single key is used for every container, regardless of its GUID. Keyring files are JSON in one of these formats:
+//   - a map of GUID to key: {"00000000000000000000000000000000": "0x..."}
+//   - the aes.json of FModel: {"mainKey": "0x...", "dynamicKeys": [{"guid": "...", "key": "0x..."}]}
+//   - the Crypto.json of a project: {"EncryptionKey": {"Guid": "...", "Key": "..."}, "SecondaryEncryptionKeys": [...]}
+// A GUID is written as 32 hexadecimal digits, optionally with dashes and braces.
+
+type Keyring struct {
+	keys   map[FGuid][]byte
+	anyKey []byte // a single key without a GUID, which is used for every container
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[FGuid][]byte)}
+}
+
+// the format of FGuid::ToString, which the engine uses for the key GUIDs
+func (g FGuid) String() string {
+	return fmt.Sprintf("%08X%08X%08X%08X", g.A, g.B, g.C, g.D)
+}
+
+func (g FGuid) isZero() bool {
+	return g == FGuid{}
+}
+
+func parseGuid(s string) (FGuid, error) {
+	var g FGuid
+	digits := strings.NewReplacer("-", "", "{", "", "}", "").Replace(strings.TrimSpace(s))
+	b, err := hex.DecodeString(digits)
+	if err != nil || len(b) != 16 {
+		return g, errors.New("invalid GUID " + s + "; expecting 32 hexadecimal digits")
+	}
+	g.A = binary.BigEndian.Uint32(b[0:])
+	g.B = binary.BigEndian.Uint32(b[4:])
+	g.C = binary.BigEndian.Uint32(b[8:])
+	g.D = binary.BigEndian.Uint32(b[12:])
+	return g, nil
+}
+
+// parseAESKey reads a key of 32 bytes in hexadecimal format or in base64
+func parseAESKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	hexKey := strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	if b, err := hex.DecodeString(hexKey); err == nil {
+		if len(b) != 32 {
+			return nil, fmt.Errorf("the AES key has %d bytes; it must have 32 bytes (64 hexadecimal digits)", len(b))
+		}
+		return b, nil
+	}
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil {
+		if len(b) != 32 {
+			return nil, fmt.Errorf("the AES key has %d bytes; it must have 32 bytes", len(b))
+		}
+		return b, nil
+	}
+	return nil, errors.New("the AES key must be written in hexadecimal format or in base64")
+}
+
+// Add adds a key; the zero GUID is the main key
+func (k *Keyring) Add(guid FGuid, key string) error {
+	b, err := parseAESKey(key)
+	if err != nil {
+		return fmt.Errorf("key %s: %w", guid, err)
+	}
+	k.keys[guid] = b
+	return nil
+}
+
+// ParseKeyring reads a single key, or a keyring file when s is the path of an existing file.
+// An empty string gives an empty keyring.
+func ParseKeyring(s string) (*Keyring, error) {
+	k := NewKeyring()
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return k, nil
+	}
+	if info, err := os.Stat(s); err == nil && !info.IsDir() {
+		return readKeyringFile(s)
+	}
+	key, err := parseAESKey(s)
+	if err != nil {
+		return nil, err
+	}
+	k.anyKey = key
+	return k, nil
+}
+
+type cryptoJSONKey struct {
+	Guid string `json:"Guid"`
+	Key  string `json:"Key"`
+}
+
+func readKeyringFile(fpath string) (*Keyring, error) {
+	b, err := os.ReadFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, fmt.Errorf("could not read the keyring %s: %w", fpath, err)
+	}
+	k := NewKeyring()
+	add := func(guid string, key string) error {
+		g := FGuid{}
+		if guid != "" {
+			if g, err = parseGuid(guid); err != nil {
+				return err
+			}
+		}
+		return k.Add(g, key)
+	}
+	// the keys are matched case-insensitively by encoding/json
+	var crypto struct {
+		EncryptionKey           *cryptoJSONKey
+		SecondaryEncryptionKeys []cryptoJSONKey
+		MainKey                 *string
+		DynamicKeys             []cryptoJSONKey
+	}
+	json.Unmarshal(b, &crypto)
+	switch {
+	case crypto.EncryptionKey != nil || crypto.SecondaryEncryptionKeys != nil:
+		if crypto.EncryptionKey != nil {
+			if err := add(crypto.EncryptionKey.Guid, crypto.EncryptionKey.Key); err != nil {
+				return nil, err
+			}
+		}
+		for _, key := range crypto.SecondaryEncryptionKeys {
+			if err := add(key.Guid, key.Key); err != nil {
+				return nil, err
+			}
+		}
+	case crypto.MainKey != nil || crypto.DynamicKeys != nil:
+		if crypto.MainKey != nil && *crypto.MainKey != "" {
+			if err := add("", *crypto.MainKey); err != nil {
+				return nil, err
+			}
+		}
+		for _, key := range crypto.DynamicKeys {
+			if err := add(key.Guid, key.Key); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		for guid, raw := range fields {
+			var key string
+			if err := json.Unmarshal(raw, &key); err != nil {
+				return nil, errors.New("the keyring " + fpath + " must map GUIDs to keys")
+			}
+			if err := add(guid, key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(k.keys) == 0 {
+		return nil, errors.New("the keyring " + fpath + " does not contain any keys")
+	}
+	return k, nil
+}
+
+// keyFor returns the key of the container with the given key GUID
+func (k *Keyring) keyFor(guid FGuid) ([]byte, error) {
+	if k == nil || (len(k.keys) == 0 && k.anyKey == nil) {
+		return nil, errors.New("encrypted file, but no AES key was provided! Please pass the aes key as a string in hexadecimal format")
+	}
+	if key, ok := k.keys[guid]; ok {
+		return key, nil
+	}
+	if k.anyKey != nil {
+		return k.anyKey, nil
+	}
+	return nil, errors.New("the keyring has no AES key for the encryption key GUID " + guid.String())
+}
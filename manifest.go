@@ -3,10 +3,14 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"sort"
+	"strconv"
 )
 
 // This file is used to extract dependency data from the .ucas file.
@@ -16,6 +20,12 @@ import (
 
 const DepFileName = "dependencies"
 
+// Manifests have a version, so older manifests can still be read.
+// Version 1 has no version field; it only has the path and chunk ID of every file, and the fields of the
+// dependencies have guessed names. Version 2 names them after the package store entries of the engine,
+// and it records the settings of the container and the metadata of every chunk, so packing can reproduce them.
+const ManifestVersion = 2
+
 // Dependencies contains data extracted from the dependencies section in the .ucas file.
 // An instance of this will be used to convert back to this section.
 type Dependencies struct {
@@ -23,12 +33,22 @@ type Dependencies struct {
 	ChunkIDToDependencies map[uint64]FileDependency
 }
 
+// FileDependency is the package store entry of a package in the container header
 type FileDependency struct {
+	FileSize      uint64   `json:"exportBundlesSize"` // the uncompressed size of the export bundles
+	ExportObjects uint32   `json:"exportCount"`
+	MostlyOne     uint32   `json:"exportBundleCount"`
+	SomeIndex     uint64   `json:"loadOrder"`
+	Dependencies  []uint64 `json:"importedPackages"` // lists the ID of each dependency
+}
+
+// the names of the FileDependency fields in manifests of version 1
+type fileDependencyV1 struct {
 	FileSize      uint64   `json:"uncompressedSize"`
 	ExportObjects uint32   `json:"exportObjects"`
 	MostlyOne     uint32   `json:"requiredValueSomehow"`
 	SomeIndex     uint64   `json:"uniqueIndex"`
-	Dependencies  []uint64 `json:"dependencies"` // lists the ID of each dependency
+	Dependencies  []uint64 `json:"dependencies"`
 }
 
 type DepsHeader struct {
@@ -63,26 +83,92 @@ type parseDependencies struct {
 }
 
 type Manifest struct {
-	Files []ManifestFile `json:"Files,omitempty"` // in the .utoc file
-	Deps  Dependencies   `json:"Dependencies,omitempty"`
+	Version    int                `json:"Version,omitempty"`    // 0 for manifests of version 1
+	MountPoint string             `json:"MountPoint,omitempty"` // the paths of the files are relative to the mount point
+	Container  *ManifestContainer `json:"Container,omitempty"`  // the settings of the original container, since version 2
+	Files      []ManifestFile     `json:"Files,omitempty"`      // in the .utoc file
+	Deps       Dependencies       `json:"Dependencies,omitempty"`
 	// Packages []UcasPackages `json:"Packages,omitempty"` // the "dependencies" in .ucas file???
 }
+
+// ManifestContainer holds the settings of the container that the manifest was created from
+type ManifestContainer struct {
+	UtocVersion          uint8    `json:"UtocVersion"`
+	ContainerID          string   `json:"ContainerId"` // in hexadecimal format
+	CompressionBlockSize uint32   `json:"CompressionBlockSize"`
+	CompressionMethods   []string `json:"CompressionMethods,omitempty"`
+	EncryptionKeyGuid    string   `json:"EncryptionKeyGuid,omitempty"` // only for encrypted containers
+}
 type UcasPackages struct {
 	PathName             string   `json:"Name"`
 	ExportBundleChunkIds []string `json:"ExportBundleChunkIds,omitempty"`
 	BulkDataChunkIds     []string `json:"BulkDataChunkIds,omitempty"`
 }
+
+// ManifestFile is a chunk in the container; all fields other than the path and chunk ID are optional.
 type ManifestFile struct {
-	Filepath string `json:"Path"`
-	ChunkID  string `json:"ChunkId"`
+	Filepath    string `json:"Path"`
+	ChunkID     string `json:"ChunkId"`
+	ChunkType   string `json:"ChunkType,omitempty"`   // the name of the type in the chunk ID, for readability
+	Order       int    `json:"Order"`                 // the position of the chunk in the original container
+	MetaFlags   *uint8 `json:"MetaFlags,omitempty"`   // the flags of the chunk in the .utoc file; nil to use the default
+	Hash        string `json:"Hash,omitempty"`        // the SHA1 hash of the uncompressed data in hexadecimal format
+	Compression string `json:"Compression,omitempty"` // the compression method of the chunk in the original container
 }
 
+// chunkCompression returns the compression method that most blocks of the file are compressed with.
+// Blocks that are stored uncompressed because compressing didn't help are ignored, unless all blocks are.
+func (u *UTocData) chunkCompression(f *GameFileMetaData) string {
+	counts := make(map[uint8]int)
+	best := uint8(0)
+	for _, b := range f.compressionBlocks {
+		if b.CompressionMethod == 0 {
+			continue
+		}
+		counts[b.CompressionMethod]++
+		if counts[b.CompressionMethod] > counts[best] {
+			best = b.CompressionMethod
+		}
+	}
+	if int(best) >= len(u.compressionMethods) {
+		return ""
+	}
+	return u.compressionMethods[best]
+}
+
+// chunks without a path are left out, as they are not unpacked and can't be packed again.
 func (u *UTocData) constructManifest(ucasPath string) (m Manifest, err error) {
-	for _, v := range u.files {
-		mf := ManifestFile{Filepath: v.filepath, ChunkID: v.chunkID.ToHexString()}
+	m.Version = ManifestVersion
+	m.MountPoint = MountPoint + u.mountPoint
+	m.Container = &ManifestContainer{
+		UtocVersion:          u.hdr.Version,
+		ContainerID:          fmt.Sprintf("%016x", uint64(u.hdr.ContainerID)),
+		CompressionBlockSize: u.hdr.CompressionBlockSize,
+		CompressionMethods:   u.compressionMethods[1:],
+	}
+	if u.hdr.isEncrypted() {
+		m.Container.EncryptionKeyGuid = u.hdr.EncryptionKeyGuid.String()
+	}
+	for i, v := range u.files {
+		if v.filepath == "" {
+			continue
+		}
+		flags := uint8(v.metadata.Flags)
+		mf := ManifestFile{
+			Filepath:    v.filepath,
+			ChunkID:     v.chunkID.ToHexString(),
+			ChunkType:   chunkTypeName(v.chunkID.Type),
+			Order:       i,
+			MetaFlags:   &flags,
+			Hash:        hex.EncodeToString(v.metadata.ChunkHash.Hash[:]),
+			Compression: u.chunkCompression(&u.files[i]),
+		}
 		m.Files = append(m.Files, mf)
 	}
 	// files part has been added, now decode the dependencies
+	if !u.hasDependencies() {
+		return m, nil
+	}
 	data, err := u.unpackDependencies(ucasPath)
 	if err != nil {
 		return m, err
@@ -92,6 +178,16 @@ func (u *UTocData) constructManifest(ucasPath string) (m Manifest, err error) {
 	return m, err
 }
 
+// writeManifest writes the manifest as indented JSON
+func writeManifest(m *Manifest, manifestPath string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(manifestPath, b, os.ModePerm)
+}
+
+// readManifest reads a manifest of any version
 func readManifest(manifestPath string) (*Manifest, error) {
 	b, err := os.ReadFile(manifestPath)
 	if err != nil {
@@ -99,9 +195,52 @@ func readManifest(manifestPath string) (*Manifest, error) {
 	}
 	var manifest Manifest
 	err = json.Unmarshal(b, &manifest)
+	if err != nil {
+		return &manifest, err
+	}
+	if manifest.Version > ManifestVersion {
+		return &manifest, fmt.Errorf("the manifest has version %d, but only versions up to %d are supported", manifest.Version, ManifestVersion)
+	}
+	if manifest.Version < 2 {
+		var v1 struct {
+			Deps struct {
+				ChunkIDToDependencies map[uint64]fileDependencyV1
+			} `json:"Dependencies"`
+		}
+		err = json.Unmarshal(b, &v1)
+		for id, dep := range v1.Deps.ChunkIDToDependencies {
+			manifest.Deps.ChunkIDToDependencies[id] = FileDependency(dep)
+		}
+	}
+	if manifest.Container != nil {
+		if _, err := manifest.Container.containerID(); err != nil {
+			return &manifest, err
+		}
+		if _, err := manifest.Container.keyGuid(); err != nil {
+			return &manifest, err
+		}
+	}
 	return &manifest, err
 }
 
+func (c *ManifestContainer) containerID() (FIoContainerID, error) {
+	if c == nil || c.ContainerID == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(c.ContainerID, 16, 64)
+	if err != nil {
+		return 0, errors.New("invalid container ID " + c.ContainerID + " in the manifest")
+	}
+	return FIoContainerID(id), nil
+}
+
+func (c *ManifestContainer) keyGuid() (FGuid, error) {
+	if c == nil || c.EncryptionKeyGuid == "" {
+		return FGuid{}, nil
+	}
+	return parseGuid(c.EncryptionKeyGuid)
+}
+
 func (s *parseDependencies) extractDependencies() *Dependencies {
 	d := Dependencies{}
 	d.ThisPackageID = s.Hdr.ThisPackageID
@@ -122,6 +261,15 @@ func (s *parseDependencies) extractDependencies() *Dependencies {
 	return &d
 }
 
+// subset returns the dependencies of the given packages; a package that has none gets an empty entry
+func (d *Dependencies) subset(packageIDs []uint64) Dependencies {
+	sub := Dependencies{ThisPackageID: d.ThisPackageID, ChunkIDToDependencies: make(map[uint64]FileDependency)}
+	for _, id := range packageIDs {
+		sub.ChunkIDToDependencies[id] = d.ChunkIDToDependencies[id]
+	}
+	return sub
+}
+
 // Deparses the Dependencies struct exactly as how it was parsed
 // This was checked using a simple diff tool.
 func (d *Dependencies) Deparse() *[]byte {
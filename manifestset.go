@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Games have several containers, and every container has its own manifest. A manifest set combines the manifests
+// into one lookup of all files and packages, so a mod can be made from the files of several containers:
+// the subset of files that the mod changes is taken from the set, optionally with the packages they depend on.
+// Paths are compared below the root of the game, so manifests with different mount points can be combined.
+// When several manifests have a file with the same path or chunk ID, the priority decides which one is kept,
+// like merging containers (see mergeContainers); the same goes for the packages in the dependencies.
+
+// a file in the set; the path is below the root of the game
+type manifestSetFile struct {
+	file   ManifestFile
+	source int // index of the manifest
+}
+
+type ManifestSet struct {
+	names     []string
+	manifests []*Manifest
+	files     []manifestSetFile // from the highest to the lowest priority
+	byPath    map[string]int    // index in files
+	byChunkID map[FIoChunkID]int
+	byPackage map[uint64][]int
+	packages  map[uint64]FileDependency
+	Conflicts []MergeConflict
+}
+
+// parseChunkID reads a chunk ID in hexadecimal format, as it is written in manifests
+func parseChunkID(s string) (FIoChunkID, error) {
+	if _, err := hex.DecodeString(s); err != nil || len(s) != 24 {
+		return FIoChunkID{}, errors.New("invalid chunk ID " + s + "; expecting 24 hexadecimal digits")
+	}
+	return FromHexString(s), nil
+}
+
+// gamePath returns the path of a file of the manifest below the root of the game
+func (m *Manifest) gamePath(f *ManifestFile) (string, error) {
+	mountPoint := MountPoint
+	if m.MountPoint != "" {
+		var err error
+		mountPoint, err = normalizeMountPoint(m.MountPoint)
+		if err != nil {
+			return "", err
+		}
+	}
+	return "/" + relativeMountPoint(mountPoint) + strings.TrimPrefix(f.Filepath, "/"), nil
+}
+
+// MergeManifests combines the manifests into one set. The names are used to report conflicts, such as the paths
+// of the manifest files. The priority is MergePriorityFirst or MergePriorityLast.
+func MergeManifests(names []string, manifests []*Manifest, priority string) (*ManifestSet, error) {
+	if len(manifests) == 0 {
+		return nil, errors.New("no manifests to merge")
+	}
+	order := make([]int, len(manifests))
+	switch strings.ToLower(priority) {
+	case MergePriorityFirst, "":
+		for i := range order {
+			order[i] = i
+		}
+	case MergePriorityLast:
+		for i := range order {
+			order[i] = len(order) - 1 - i
+		}
+	default:
+		return nil, errors.New("unknown merge priority " + priority + "; use first or last")
+	}
+
+	s := &ManifestSet{
+		names:     names,
+		manifests: manifests,
+		byPath:    make(map[string]int),
+		byChunkID: make(map[FIoChunkID]int),
+		byPackage: make(map[uint64][]int),
+		packages:  make(map[uint64]FileDependency),
+		Conflicts: []MergeConflict{},
+	}
+	conflicts := make(map[string]int) // index in the list of conflicts
+	addConflict := func(kind string, key string, winner int, loser int) {
+		idx, ok := conflicts[kind+key]
+		if !ok {
+			idx = len(s.Conflicts)
+			conflicts[kind+key] = idx
+			s.Conflicts = append(s.Conflicts, MergeConflict{
+				Path:    s.files[winner].file.Filepath,
+				ChunkID: s.files[winner].file.ChunkID,
+				Kind:    kind,
+				Winner:  s.names[s.files[winner].source],
+			})
+		}
+		s.Conflicts[idx].Overridden = append(s.Conflicts[idx].Overridden, s.names[loser])
+	}
+
+	for _, source := range order {
+		m := manifests[source]
+		for _, f := range m.Files {
+			if f.Filepath == DepFileName {
+				continue
+			}
+			chunkID, err := parseChunkID(f.ChunkID)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", names[source], f.Filepath, err)
+			}
+			fpath, err := m.gamePath(&f)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", names[source], err)
+			}
+			if winner, ok := s.byPath[fpath]; ok {
+				addConflict(MergeConflictPath, fpath, winner, source)
+				continue
+			}
+			if winner, ok := s.byChunkID[chunkID]; ok {
+				addConflict(MergeConflictChunkID, f.ChunkID, winner, source)
+				continue
+			}
+			f.Filepath = fpath
+			s.byPath[fpath] = len(s.files)
+			s.byChunkID[chunkID] = len(s.files)
+			s.byPackage[chunkID.ID] = append(s.byPackage[chunkID.ID], len(s.files))
+			s.files = append(s.files, manifestSetFile{file: f, source: source})
+		}
+		for id, dep := range m.Deps.ChunkIDToDependencies {
+			if _, ok := s.packages[id]; !ok {
+				s.packages[id] = dep
+			}
+		}
+	}
+	return s, nil
+}
+
+// readManifestSet reads the manifest files and merges them; the paths of the files are used as their names
+func readManifestSet(manifestPaths []string, priority string) (*ManifestSet, error) {
+	var manifests []*Manifest
+	for _, p := range manifestPaths {
+		m, err := readManifest(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		manifests = append(manifests, m)
+	}
+	return MergeManifests(manifestPaths, manifests, priority)
+}
+
+// readPathList reads a file with one path or chunk ID per line; empty lines and lines starting with # are skipped
+func readPathList(fpath string) ([]string, error) {
+	b, err := os.ReadFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, line)
+	}
+	return paths, nil
+}
+
+// find returns the index of a file by its path below the root of the game, or by its chunk ID in hexadecimal format
+func (s *ManifestSet) find(key string) (int, error) {
+	fpath := "/" + strings.TrimPrefix(strings.ReplaceAll(key, "\\", "/"), "/")
+	if i, ok := s.byPath[fpath]; ok {
+		return i, nil
+	}
+	if chunkID, err := parseChunkID(key); err == nil {
+		if i, ok := s.byChunkID[chunkID]; ok {
+			return i, nil
+		}
+	}
+	return -1, errors.New("file not found in the manifests: " + key)
+}
+
+// Manifest returns one manifest with all files of the set
+func (s *ManifestSet) Manifest() *Manifest {
+	selected := make([]bool, len(s.files))
+	for i := range selected {
+		selected[i] = true
+	}
+	return s.manifest(selected)
+}
+
+// Subset returns a manifest with the given files, which are found by their path below the root of the game
+// or by their chunk ID. With dependencies, the files of all packages that the files depend on are added as well,
+// as far as they are in the set.
+func (s *ManifestSet) Subset(paths []string, withDependencies bool) (*Manifest, error) {
+	selected := make([]bool, len(s.files))
+	var queue []uint64
+	visited := make(map[uint64]bool)
+	for _, p := range paths {
+		i, err := s.find(p)
+		if err != nil {
+			return nil, err
+		}
+		selected[i] = true
+		id := FromHexString(s.files[i].file.ChunkID).ID
+		if !visited[id] {
+			visited[id] = true
+			queue = append(queue, id)
+		}
+	}
+	for withDependencies && len(queue) != 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, dep := range s.packages[id].Dependencies {
+			if visited[dep] {
+				continue
+			}
+			visited[dep] = true
+			queue = append(queue, dep)
+			for _, i := range s.byPackage[dep] {
+				selected[i] = true
+			}
+		}
+	}
+	return s.manifest(selected), nil
+}
+
+// manifest creates a manifest with the selected files. When all files come from manifests with the same mount point,
+// that mount point is kept; otherwise the manifest has no mount point, so the paths are relative to the root of the game.
+// The settings of the container are only kept when all files come from the same manifest.
+func (s *ManifestSet) manifest(selected []bool) *Manifest {
+	var sources []int
+	seen := make(map[int]bool)
+	for i, f := range s.files {
+		if selected[i] && !seen[f.source] {
+			seen[f.source] = true
+			sources = append(sources, f.source)
+		}
+	}
+	m := &Manifest{
+		Version: ManifestVersion,
+		Deps:    Dependencies{ChunkIDToDependencies: make(map[uint64]FileDependency)},
+	}
+	if len(sources) == 0 {
+		return m
+	}
+	first := s.manifests[sources[0]]
+	m.MountPoint = first.MountPoint
+	for _, source := range sources {
+		if s.manifests[source].MountPoint != first.MountPoint {
+			m.MountPoint = ""
+		}
+	}
+	if len(sources) == 1 {
+		m.Container = first.Container
+	}
+	mountPoint := MountPoint
+	if m.MountPoint != "" {
+		mountPoint, _ = normalizeMountPoint(m.MountPoint)
+	}
+	for i, f := range s.files {
+		if !selected[i] {
+			continue
+		}
+		file := f.file
+		file.Filepath = "/" + strings.TrimPrefix(file.Filepath, "/"+relativeMountPoint(mountPoint))
+		m.Files = append(m.Files, file)
+		id := FromHexString(file.ChunkID).ID
+		if dep, ok := s.packages[id]; ok {
+			m.Deps.ChunkIDToDependencies[id] = dep
+		}
+	}
+	// the container header of the manifest with the highest priority
+	for _, f := range first.Files {
+		if f.Filepath == DepFileName {
+			m.Deps.ThisPackageID = first.Deps.ThisPackageID
+			m.Files = append(m.Files, f)
+			break
+		}
+	}
+	return m
+}
+
+// Validate checks that every package that a package depends on is in the set, and that every package
+// of an export bundle has an entry in the dependencies. All problems are listed in the error.
+func (s *ManifestSet) Validate() error {
+	var problems []string
+	ids := make([]uint64, 0, len(s.packages))
+	for id := range s.packages {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		for _, dep := range s.packages[id].Dependencies {
+			if _, ok := s.packages[dep]; !ok {
+				problems = append(problems, fmt.Sprintf("package %016x depends on package %016x, which is not in the manifests", id, dep))
+			}
+		}
+	}
+	for _, f := range s.files {
+		chunkID := FromHexString(f.file.ChunkID)
+		if chunkID.Type != ExportBundleDataChunkType {
+			continue
+		}
+		if _, ok := s.packages[chunkID.ID]; !ok {
+			problems = append(problems, fmt.Sprintf("%s has no entry in the dependencies of %s", f.file.Filepath, s.names[f.source]))
+		}
+	}
+	if len(problems) != 0 {
+		return fmt.Errorf("%d problems found:\n%s", len(problems), strings.Join(problems, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+)
+
+// Merging combines several mod containers into one, so that fewer containers have to be mounted.
+// The compressed blocks are copied as they are, only the .utoc file and the container header are rebuilt.
+// When two containers have a file with the same path or the same chunk ID, only one of them can be kept.
+// Paths are compared below the root of the game, so containers with different mount points can be merged.
+// Chunks without a path are carried over as well; they can only conflict by their chunk ID.
+// Which one is decided by the priority; the order in which the containers are passed is used for this.
+
+const (
+	MergePriorityFirst = "first" // the first container that contains a file wins
+	MergePriorityLast  = "last"  // the last container that contains a file wins, like mounting them in order
+)
+
+const (
+	MergeConflictPath    = "path"
+	MergeConflictChunkID = "chunkId"
+)
+
+// a container that must be merged or searched; the .ucas file must not be encrypted
+type MergeSource struct {
+	name     string // used in the conflict report, e.g. the path to the .utoc file
+	toc      *UTocData
+	ucasPath string
+}
+
+type MergeConflict struct {
+	Path       string   `json:"path"`
+	ChunkID    string   `json:"chunkId"`
+	Kind       string   `json:"kind"`
+	Winner     string   `json:"winner"`
+	Overridden []string `json:"overridden"`
+}
+
+type MergeReport struct {
+	Files     int             `json:"files"`
+	Conflicts []MergeConflict `json:"conflicts"`
+}
+
+func (r *MergeReport) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// a file that was chosen to be included in the merged container
+type mergeChoice struct {
+	source *MergeSource
+	file   *GameFileMetaData
+	fpath  string // the path of the file below the root of the game
+	deps   *Dependencies
+}
+
+// mergeContainers writes one container with the files of all sources.
+// The container ID of the highest priority source is used for the merged container.
+func mergeContainers(sources []MergeSource, priority string, outFilename string) (*MergeReport, error) {
+	if len(sources) == 0 {
+		return nil, errors.New("no containers to merge")
+	}
+	for _, s := range sources {
+		if err := s.toc.checkRewritable(); err != nil {
+			return nil, errors.New(s.name + ": " + err.Error())
+		}
+	}
+	// order the sources from highest to lowest priority
+	ordered := []*MergeSource{}
+	switch strings.ToLower(priority) {
+	case MergePriorityFirst, "":
+		for i := range sources {
+			ordered = append(ordered, &sources[i])
+		}
+	case MergePriorityLast:
+		for i := len(sources) - 1; i >= 0; i-- {
+			ordered = append(ordered, &sources[i])
+		}
+	default:
+		return nil, errors.New("unknown merge priority " + priority + "; use first or last")
+	}
+
+	report := MergeReport{Conflicts: []MergeConflict{}}
+	conflicts := make(map[string]int) // index in the list of conflicts
+	byPath := make(map[string]*mergeChoice)
+	byChunkID := make(map[FIoChunkID]*mergeChoice)
+	var choices []*mergeChoice
+	addConflict := func(kind string, key string, winner *mergeChoice, loser *MergeSource) {
+		idx, ok := conflicts[kind+key]
+		if !ok {
+			idx = len(report.Conflicts)
+			conflicts[kind+key] = idx
+			report.Conflicts = append(report.Conflicts, MergeConflict{
+				Path:    winner.fpath,
+				ChunkID: winner.file.chunkID.ToHexString(),
+				Kind:    kind,
+				Winner:  winner.source.name,
+			})
+		}
+		report.Conflicts[idx].Overridden = append(report.Conflicts[idx].Overridden, loser.name)
+	}
+
+	hasDependencies := false
+	for _, src := range ordered {
+		// containers without a container header don't add packages
+		deps := &Dependencies{ChunkIDToDependencies: make(map[uint64]FileDependency)}
+		if src.toc.hasDependencies() {
+			hasDependencies = true
+			depData, err := src.toc.unpackDependencies(src.ucasPath)
+			if err != nil {
+				return nil, err
+			}
+			deps, err = ParseDependencies(*depData)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for i, f := range src.toc.files {
+			if f.filepath == DepFileName {
+				continue
+			}
+			fpath := src.toc.gamePath(&src.toc.files[i])
+			if winner, ok := byPath[fpath]; ok && fpath != "" {
+				addConflict(MergeConflictPath, fpath, winner, src)
+				continue
+			}
+			if winner, ok := byChunkID[f.chunkID]; ok {
+				addConflict(MergeConflictChunkID, f.chunkID.ToHexString(), winner, src)
+				continue
+			}
+			choice := &mergeChoice{source: src, file: &src.toc.files[i], fpath: fpath, deps: deps}
+			if fpath != "" {
+				byPath[fpath] = choice
+			}
+			byChunkID[f.chunkID] = choice
+			choices = append(choices, choice)
+		}
+	}
+	// the container header only contains the packages of the files that were chosen
+	merged := Dependencies{
+		ThisPackageID:         uint64(ordered[0].toc.hdr.ContainerID),
+		ChunkIDToDependencies: make(map[uint64]FileDependency),
+	}
+	for _, c := range choices {
+		if _, ok := merged.ChunkIDToDependencies[c.file.chunkID.ID]; ok {
+			continue
+		}
+		if dep, ok := c.deps.ChunkIDToDependencies[c.file.chunkID.ID]; ok {
+			merged.ChunkIDToDependencies[c.file.chunkID.ID] = dep
+		}
+	}
+
+	w, err := newContainerWriter(outFilename, ordered[0].toc.hdr.CompressionBlockSize)
+	if err != nil {
+		return nil, err
+	}
+	w.containerID = ordered[0].toc.hdr.ContainerID
+	paths := make([]string, len(choices))
+	for i, c := range choices {
+		paths[i] = c.fpath
+	}
+	w.mountPoint, paths = foldMountPoint(MountPoint, paths)
+	openFiles := make(map[*MergeSource]*os.File)
+	defer func() {
+		for _, f := range openFiles {
+			f.Close()
+		}
+	}()
+	for i, c := range choices {
+		ucas, ok := openFiles[c.source]
+		if !ok {
+			ucas, err = os.Open(c.source.ucasPath)
+			if err != nil {
+				w.abort()
+				return nil, err
+			}
+			openFiles[c.source] = ucas
+		}
+		file := *c.file
+		file.filepath = paths[i]
+		err = w.copyChunk(c.source.toc, ucas, &file)
+		if err != nil {
+			w.abort()
+			return nil, err
+		}
+	}
+	if hasDependencies {
+		err = w.writeDependencies(&merged)
+		if err != nil {
+			w.abort()
+			return nil, err
+		}
+	}
+	err = w.finish(outFilename, nil)
+	if err != nil {
+		return nil, err
+	}
+	report.Files = len(choices)
+	return &report, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// The directory index starts with the mount point of the container, such as "../../../Game/Content/",
+// and the paths of the files are relative to it. Unpacking places the files below the mount point,
+// so the directory that is packed again always mirrors the root of the game ("../../../").
+// Like the packer of the engine, the directories that all files have in common are moved into the mount point.
+
+// normalizeMountPoint returns the mount point with forward slashes, starting with "../../../" and ending with a slash
+func normalizeMountPoint(mountPoint string) (string, error) {
+	mountPoint = strings.ReplaceAll(mountPoint, "\\", "/")
+	if !strings.HasPrefix(mountPoint, MountPoint) {
+		return "", errors.New("the mount point " + mountPoint + " must start with " + MountPoint)
+	}
+	if !strings.HasSuffix(mountPoint, "/") {
+		mountPoint += "/"
+	}
+	return mountPoint, nil
+}
+
+// the directory of the mount point below the root of the game, which is where unpacked files are placed
+func relativeMountPoint(mountPoint string) string {
+	return strings.TrimPrefix(mountPoint, MountPoint)
+}
+
+// rebaseMountPath returns the path of a file relative to another mount point; the file must be below it.
+func rebaseMountPath(fpath string, from string, to string) (string, error) {
+	full := "/" + relativeMountPoint(from) + strings.TrimPrefix(fpath, "/")
+	rel := "/" + relativeMountPoint(to)
+	if !strings.HasPrefix(full, rel) {
+		return "", errors.New(full + " is not below the mount point " + to)
+	}
+	return "/" + strings.TrimPrefix(full, rel), nil
+}
+
+// foldMountPoint moves the directories that all paths have in common into the mount point.
+// The paths start with a slash; empty paths (the dependencies) are ignored.
+func foldMountPoint(mountPoint string, paths []string) (string, []string) {
+	var common []string
+	first := true
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		dirs := strings.Split(strings.TrimPrefix(p, "/"), "/")
+		dirs = dirs[:len(dirs)-1]
+		if first {
+			common = dirs
+			first = false
+			continue
+		}
+		n := 0
+		for n < len(common) && n < len(dirs) && common[n] == dirs[n] {
+			n++
+		}
+		common = common[:n]
+	}
+	if len(common) == 0 {
+		return mountPoint, paths
+	}
+	prefix := "/" + strings.Join(common, "/")
+	folded := make([]string, len(paths))
+	for i, p := range paths {
+		if p != "" {
+			p = strings.TrimPrefix(p, prefix)
+		}
+		folded[i] = p
+	}
+	return mountPoint + strings.Join(common, "/") + "/", folded
+}
+
+// containerMountPoint chooses the mount point of a new container and makes the paths of the files, which are
+// relative to the root of the game, relative to it. An explicit mount point is used as it is. Otherwise the mount
+// point of the manifest is kept, and when the manifest has none, the directories that all files have in common
+// are folded into the mount point.
+func containerMountPoint(files []GameFileMetaData, manifestMountPoint string, mountPoint string) (string, error) {
+	if mountPoint == "" {
+		mountPoint = manifestMountPoint
+	}
+	if mountPoint != "" {
+		mountPoint, err := normalizeMountPoint(mountPoint)
+		if err != nil {
+			return "", err
+		}
+		for i := range files {
+			if files[i].filepath == "" {
+				continue
+			}
+			files[i].filepath, err = rebaseMountPath(files[i].filepath, MountPoint, mountPoint)
+			if err != nil {
+				return "", err
+			}
+		}
+		return mountPoint, nil
+	}
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.filepath
+	}
+	mountPoint, paths = foldMountPoint(MountPoint, paths)
+	for i := range files {
+		files[i].filepath = paths[i]
+	}
+	return mountPoint, nil
+}
+
+// gamePath returns the path of a file below the root of the game, such as "/Game/Content/Maps/Map.umap".
+// Chunks without a path, including the dependencies, have no path below the root either.
+func (u *UTocData) gamePath(f *GameFileMetaData) string {
+	if f.filepath == "" || f.filepath == DepFileName {
+		return ""
+	}
+	return "/" + u.mountPoint + strings.TrimPrefix(f.filepath, "/")
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Oodle is a proprietary library, which is not distributed with this tool.
+// When the library can be loaded, it's used for both compression and decompression.
+// Otherwise, Oodle compressed data is decompressed by the decoder in oodle_decoder.go;
+// compressing with Oodle always requires the library.
+
+// OodleLibraryEnv is the environment variable that may hold the path of the Oodle library
+const OodleLibraryEnv = "UECASTOC_OODLE_LIB"
+
+// compressors and compression level of the Oodle library
+const (
+	OodleKraken        = 8
+	OodleMermaid       = 9
+	OodleSelkie        = 11
+	OodleLeviathan     = 13
+	OodleLevelOptimal3 = 7
+)
+
+// oodleLibrary is a loaded Oodle library (oo2core_9_win64.dll, liboo2corelinux64.so, ...)
+type oodleLibrary interface {
+	decompress(in []byte, outputSize int) ([]byte, error)
+	compress(in []byte, compressor int, level int) ([]byte, error)
+}
+
+var oodleState struct {
+	sync.Mutex
+	path       string // set by setOodleLibrary, empty to use the environment variable or the default
+	lib        oodleLibrary
+	loadErr    error // the error of the last attempt, so loading isn't retried for every block
+	tried      bool
+	downloaded bool // the default library is downloaded at most once
+}
+
+// setOodleLibrary sets the path of the Oodle library that is used from now on.
+// An empty path resets to the default lookup. The library is loaded immediately, so a wrong path is reported here.
+func setOodleLibrary(path string) error {
+	oodleState.Lock()
+	defer oodleState.Unlock()
+	oodleState.path = path
+	oodleState.lib = nil
+	oodleState.loadErr = nil
+	oodleState.tried = false
+	if path == "" {
+		return nil
+	}
+	lib, err := loadOodleLibrary(path)
+	oodleState.tried = true
+	if err != nil {
+		oodleState.loadErr = fmt.Errorf("could not load the Oodle library %s: %v", path, err)
+		return oodleState.loadErr
+	}
+	oodleState.lib = lib
+	return nil
+}
+
+// getOodleLibrary returns the loaded Oodle library; the configured path takes precedence over
+// the environment variable, which takes precedence over the default locations.
+// With download, the default library is downloaded once when it can't be loaded, where that is possible.
+func getOodleLibrary(download bool) (oodleLibrary, error) {
+	oodleState.Lock()
+	defer oodleState.Unlock()
+	path := oodleState.path
+	if path == "" {
+		path = os.Getenv(OodleLibraryEnv)
+	}
+	if !oodleState.tried {
+		oodleState.tried = true
+		var err error
+		if path != "" {
+			oodleState.lib, err = loadOodleLibrary(path)
+			if err != nil {
+				err = fmt.Errorf("could not load the Oodle library %s: %v", path, err)
+			}
+		} else {
+			oodleState.lib, err = loadDefaultOodleLibrary()
+		}
+		oodleState.loadErr = err
+	}
+	if oodleState.loadErr != nil && download && path == "" && !oodleState.downloaded {
+		oodleState.downloaded = true
+		if downloadOodleLibrary() == nil {
+			// the default library was downloaded, try again
+			oodleState.lib, oodleState.loadErr = loadDefaultOodleLibrary()
+		}
+	}
+	return oodleState.lib, oodleState.loadErr
+}
+
+// the Oodle compressors, by name
+var oodleCompressors = map[string]int{
+	"kraken":    OodleKraken,
+	"mermaid":   OodleMermaid,
+	"selkie":    OodleSelkie,
+	"leviathan": OodleLeviathan,
+}
+
+type oodleCodec struct {
+	compressor int
+	level      int
+}
+
+// The settings for Oodle _could_ be modified, but this is what Unreal Engine states as example
+// https://docs.unrealengine.com/4.27/en-US/TestingAndOptimization/Oodle/Data/
+// so the default is Kraken at level 7 (Optimal3).
+func newOodleCodec(options CodecOptions) (Codec, error) {
+	if err := options.unsupported(true, true, false); err != nil {
+		return nil, err
+	}
+	if err := options.levelInRange(-4, 9); err != nil {
+		return nil, err
+	}
+	c := &oodleCodec{compressor: OodleKraken, level: OodleLevelOptimal3}
+	if options.Compressor != "" {
+		compressor, ok := oodleCompressors[options.Compressor]
+		if !ok {
+			return nil, errors.New("unknown Oodle compressor " + options.Compressor + ", use kraken, mermaid, selkie or leviathan")
+		}
+		c.compressor = compressor
+	}
+	if options.LevelSet {
+		c.level = options.Level
+	}
+	return c, nil
+}
+
+func (c *oodleCodec) Name() string { return "Oodle" }
+
+func (c *oodleCodec) Decompress(dst []byte, src []byte) error {
+	if lib, err := getOodleLibrary(false); err == nil {
+		output, err := lib.decompress(src, len(dst))
+		if err != nil {
+			return err
+		}
+		copy(dst, output)
+		return nil
+	}
+	// no library, use the decoder written in Go
+	if err := oodleDecompress(src, dst); err != nil {
+		return fmt.Errorf("oodle decompression: %v", err)
+	}
+	return nil
+}
+
+func (c *oodleCodec) Compress(dst []byte, src []byte) ([]byte, error) {
+	lib, err := getOodleLibrary(true)
+	if err != nil {
+		return nil, errors.New("oodle compression requires the Oodle library: " + err.Error() +
+			"; set its path with setOodleLibraryPath or the " + OodleLibraryEnv + " environment variable")
+	}
+	compressedData, err := lib.compress(src, c.compressor, c.level)
+	if err != nil {
+		return nil, err
+	}
+	return append(dst, compressedData...), nil
+}
+
+// the size of the buffer that the Oodle library needs for compressing n bytes
+func oodleCompressBound(n int) int {
+	return n + 274*((n+0x3FFFF)/0x40000) + 64
+}
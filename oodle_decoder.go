@@ -0,0 +1,1488 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+)
+
+// A decoder for the Oodle formats Kraken, Mermaid, Selkie and Leviathan, written in Go.
+// It is used when no Oodle library is available, so that Oodle containers can be read anywhere.
+// The format is closed; this follows the reverse engineered decoder of the ooz project
+// (https://github.com/powzix/ooz), which is GPL-3 licensed as well.
+// Compressing still requires the Oodle library.
+//
+// The compressed data consists of quanta of 256KB; each quantum consists of chunks of 128KB.
+// A chunk is either stored, entropy coded as a whole, or LZ coded. The LZ coded chunks consist of
+// several entropy coded streams (literals, commands, offsets, lengths), which are combined by the LZ decoder.
+
+var errOodleCorrupt = errors.New("oodle: the compressed data is corrupt")
+
+const (
+	oodleDecoderKraken    = 6
+	oodleDecoderMermaid   = 10 // Selkie uses the Mermaid decoder as well
+	oodleDecoderLeviathan = 12
+)
+
+type oodleHeader struct {
+	decoderType  int
+	uncompressed bool
+	useChecksums bool
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// the header at the start of every 256KB of output
+func parseOodleHeader(src []byte) (oodleHeader, error) {
+	var hdr oodleHeader
+	if len(src) < 2 || src[0]&0xF != 0xC || (src[0]>>4)&3 != 0 {
+		return hdr, errOodleCorrupt
+	}
+	hdr.uncompressed = (src[0]>>6)&1 == 1
+	hdr.decoderType = int(src[1] & 0x7F)
+	hdr.useChecksums = src[1]>>7 == 1
+	switch hdr.decoderType {
+	case oodleDecoderKraken, oodleDecoderMermaid, oodleDecoderLeviathan:
+		return hdr, nil
+	}
+	return hdr, fmt.Errorf("oodle: decoder type %d is not supported, only Kraken, Mermaid, Selkie and Leviathan are", hdr.decoderType)
+}
+
+// oodleDecompress decompresses src into dst, which must have the exact size of the uncompressed data.
+func oodleDecompress(src []byte, dst []byte) error {
+	var hdr oodleHeader
+	pos := 0
+	for offset := 0; offset < len(dst); {
+		if offset&0x3FFFF == 0 {
+			var err error
+			hdr, err = parseOodleHeader(src[pos:])
+			if err != nil {
+				return err
+			}
+			pos += 2
+		}
+		dstCount := minInt(len(dst)-offset, 0x40000)
+		if hdr.uncompressed {
+			if len(src)-pos < dstCount {
+				return errOodleCorrupt
+			}
+			copy(dst[offset:], src[pos:pos+dstCount])
+			pos += dstCount
+		} else {
+			n, err := decodeOodleQuantum(&hdr, src[pos:], dst, offset, dstCount)
+			if err != nil {
+				return err
+			}
+			pos += n
+		}
+		offset += dstCount
+	}
+	if pos != len(src) {
+		return errOodleCorrupt
+	}
+	return nil
+}
+
+// decodes one quantum to dst[offset:offset+dstCount]; everything before offset can be referenced by matches
+func decodeOodleQuantum(hdr *oodleHeader, src []byte, dst []byte, offset int, dstCount int) (int, error) {
+	if len(src) < 3 {
+		return 0, errOodleCorrupt
+	}
+	v := int(src[0])<<16 | int(src[1])<<8 | int(src[2])
+	size := v & 0x3FFFF
+	if size == 0x3FFFF {
+		// the quantum consists of a single repeated byte
+		if v>>18 != 1 || len(src) < 4 {
+			return 0, errOodleCorrupt
+		}
+		out := dst[offset : offset+dstCount]
+		for i := range out {
+			out[i] = src[3]
+		}
+		return 4, nil
+	}
+	compressedSize := size + 1
+	pos := 3
+	if hdr.useChecksums {
+		pos += 3 // the checksum is not verified
+	}
+	if compressedSize > dstCount || len(src)-pos < compressedSize {
+		return 0, errOodleCorrupt
+	}
+	quantum := src[pos : pos+compressedSize]
+	if compressedSize == dstCount {
+		copy(dst[offset:], quantum)
+		return pos + compressedSize, nil
+	}
+	var decodeLZ oodleLZDecoder
+	switch hdr.decoderType {
+	case oodleDecoderKraken:
+		decodeLZ = krakenDecodeLZ
+	case oodleDecoderMermaid:
+		decodeLZ = mermaidDecodeLZ
+	case oodleDecoderLeviathan:
+		decodeLZ = leviathanDecodeLZ
+	}
+	n, err := decodeOodleChunks(quantum, dst, offset, dstCount, decodeLZ)
+	if err != nil {
+		return 0, err
+	}
+	if n != compressedSize {
+		return 0, errOodleCorrupt
+	}
+	return pos + compressedSize, nil
+}
+
+// decodes the LZ coded chunk src to dst[offset:offset+dstCount]
+type oodleLZDecoder func(mode int, src []byte, dst []byte, offset int, dstCount int) error
+
+func decodeOodleChunks(src []byte, dst []byte, offset int, dstCount int, decodeLZ oodleLZDecoder) (int, error) {
+	pos := 0
+	end := offset + dstCount
+	for offset < end {
+		count := minInt(end-offset, 0x20000)
+		if len(src)-pos < 4 {
+			return 0, errOodleCorrupt
+		}
+		chunkHdr := int(src[pos])<<16 | int(src[pos+1])<<8 | int(src[pos+2])
+		var used int
+		if chunkHdr&0x800000 == 0 {
+			// entropy coded, without any matches
+			out, n, err := decodeOodleBytes(src[pos:], count)
+			if err != nil {
+				return 0, err
+			}
+			if len(out) != count {
+				return 0, errOodleCorrupt
+			}
+			copy(dst[offset:], out)
+			used = n
+		} else {
+			pos += 3
+			used = chunkHdr & 0x7FFFF
+			mode := (chunkHdr >> 19) & 0xF
+			if len(src)-pos < used {
+				return 0, errOodleCorrupt
+			}
+			if used < count {
+				if err := decodeLZ(mode, src[pos:pos+used], dst, offset, count); err != nil {
+					return 0, err
+				}
+			} else if used > count || mode != 0 {
+				return 0, errOodleCorrupt
+			} else {
+				copy(dst[offset:], src[pos:pos+used])
+			}
+		}
+		pos += used
+		offset += count
+	}
+	return pos, nil
+}
+
+// reads the sizes at the start of an entropy coded block, which is not stored as is
+func parseOodleBlockSizes(src []byte) (srcSize int, dstSize int, pos int, err error) {
+	if src[0] >= 0x80 {
+		// 10 bit sizes
+		if len(src) < 3 {
+			return 0, 0, 0, errOodleCorrupt
+		}
+		v := int(src[0])<<16 | int(src[1])<<8 | int(src[2])
+		srcSize = v & 0x3FF
+		dstSize = srcSize + (v>>10)&0x3FF + 1
+		return srcSize, dstSize, 3, nil
+	}
+	// 18 bit sizes
+	if len(src) < 5 {
+		return 0, 0, 0, errOodleCorrupt
+	}
+	v := int(binary.BigEndian.Uint32(src[1:]))
+	srcSize = v & 0x3FFFF
+	dstSize = ((v>>18)|int(src[0])<<14)&0x3FFFF + 1
+	if srcSize >= dstSize {
+		return 0, 0, 0, errOodleCorrupt
+	}
+	return srcSize, dstSize, 5, nil
+}
+
+// reads the size of a stored block
+func parseOodleStoredSize(src []byte) (size int, pos int, err error) {
+	if src[0] >= 0x80 {
+		return (int(src[0])<<8 | int(src[1])) & 0xFFF, 2, nil
+	}
+	if len(src) < 3 {
+		return 0, 0, errOodleCorrupt
+	}
+	size = int(src[0])<<16 | int(src[1])<<8 | int(src[2])
+	if size&^0x3FFFF != 0 {
+		return 0, 0, errOodleCorrupt
+	}
+	return size, 3, nil
+}
+
+// decodeOodleBytes decodes an entropy coded block of at most maxOutput bytes.
+// Returns the decoded data and the number of bytes that were read from src.
+// Stored data is not copied, so the result may point into src.
+func decodeOodleBytes(src []byte, maxOutput int) ([]byte, int, error) {
+	if len(src) < 2 {
+		return nil, 0, errOodleCorrupt
+	}
+	chunkType := int(src[0]>>4) & 7
+	if chunkType == 0 {
+		size, pos, err := parseOodleStoredSize(src)
+		if err != nil {
+			return nil, 0, err
+		}
+		if size > maxOutput || len(src)-pos < size {
+			return nil, 0, errOodleCorrupt
+		}
+		return src[pos : pos+size], pos + size, nil
+	}
+	srcSize, dstSize, pos, err := parseOodleBlockSizes(src)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(src)-pos < srcSize || dstSize > maxOutput {
+		return nil, 0, errOodleCorrupt
+	}
+	data := src[pos : pos+srcSize]
+	dst := make([]byte, dstSize)
+	var n int
+	switch chunkType {
+	case 1:
+		n, err = decodeTans(data, dst)
+	case 2, 4:
+		n, err = decodeHuffman(data, dst, chunkType>>1)
+	case 3:
+		n, err = decodeRLE(data, dst)
+	case 5:
+		n, err = decodeRecursive(data, dst)
+	default:
+		err = errOodleCorrupt
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if n != srcSize {
+		return nil, 0, errOodleCorrupt
+	}
+	return dst, pos + srcSize, nil
+}
+
+// oodleBlockSize returns the decoded size of the entropy coded block, without decoding it.
+func oodleBlockSize(src []byte, capacity int) (int, error) {
+	if len(src) < 2 {
+		return 0, errOodleCorrupt
+	}
+	chunkType := int(src[0]>>4) & 7
+	if chunkType == 0 {
+		size, pos, err := parseOodleStoredSize(src)
+		if err != nil {
+			return 0, err
+		}
+		if size > capacity || len(src)-pos < size {
+			return 0, errOodleCorrupt
+		}
+		return size, nil
+	}
+	if chunkType >= 6 {
+		return 0, errOodleCorrupt
+	}
+	srcSize, dstSize, pos, err := parseOodleBlockSizes(src)
+	if err != nil {
+		return 0, err
+	}
+	if len(src)-pos < srcSize || dstSize > capacity {
+		return 0, errOodleCorrupt
+	}
+	return dstSize, nil
+}
+
+/* Bit readers */
+
+// The bitReader reads bits from the most significant bit first. The bits are kept in a 32 bit
+// register, which is refilled a byte at a time so that it always contains at least 24 bits.
+type bitReader struct {
+	src    []byte
+	p      int // forwards: the next byte to read; backwards: the last byte that was read
+	pEnd   int // forwards: the end of the data; backwards: the start of the data
+	bits   uint32
+	bitpos int
+}
+
+func newBitReader(src []byte, start int, end int) *bitReader {
+	br := &bitReader{src: src, p: start, pEnd: end, bitpos: 24}
+	br.refill()
+	return br
+}
+
+func newBackwardBitReader(src []byte, start int, end int) *bitReader {
+	br := &bitReader{src: src, p: end, pEnd: start, bitpos: 24}
+	br.refillBackwards()
+	return br
+}
+
+func (br *bitReader) refill() {
+	for br.bitpos > 0 {
+		if br.p < br.pEnd {
+			br.bits |= uint32(br.src[br.p]) << uint(br.bitpos)
+		}
+		br.bitpos -= 8
+		br.p++
+	}
+}
+
+func (br *bitReader) refillBackwards() {
+	for br.bitpos > 0 {
+		br.p--
+		if br.p >= br.pEnd && br.p >= 0 {
+			br.bits |= uint32(br.src[br.p]) << uint(br.bitpos)
+		}
+		br.bitpos -= 8
+	}
+}
+
+func (br *bitReader) refillDirection(backwards bool) {
+	if backwards {
+		br.refillBackwards()
+	} else {
+		br.refill()
+	}
+}
+
+// the position of the first byte of which no bits were read yet
+func (br *bitReader) bytePos() int {
+	return br.p - (24-br.bitpos)>>3
+}
+
+// the position after the last byte of which no bits were read yet, when reading backwards
+func (br *bitReader) bytePosBackwards() int {
+	return br.p + (24-br.bitpos)>>3
+}
+
+// continues reading at the given bit of the given byte
+func (br *bitReader) resetAt(p int, bitpos int) {
+	br.p = p
+	br.bits = 0
+	br.bitpos = 24
+	br.refill()
+	br.bits <<= uint(bitpos)
+	br.bitpos += bitpos
+}
+
+func (br *bitReader) readBit() uint32 {
+	br.refill()
+	return br.readBitNoRefill()
+}
+
+func (br *bitReader) readBitNoRefill() uint32 {
+	r := br.bits >> 31
+	br.bits <<= 1
+	br.bitpos++
+	return r
+}
+
+// reads n bits (at most 24) without refilling, n may be zero
+func (br *bitReader) readBitsNoRefill(n int) uint32 {
+	r := br.bits >> uint(32-n)
+	br.bits <<= uint(n)
+	br.bitpos += n
+	return r
+}
+
+func (br *bitReader) readMoreThan24Bits(n int, backwards bool) uint32 {
+	var r uint32
+	if n <= 24 {
+		r = br.readBitsNoRefill(n)
+	} else {
+		r = br.readBitsNoRefill(24) << uint(n-24)
+		br.refillDirection(backwards)
+		r += br.readBitsNoRefill(n - 24)
+	}
+	br.refillDirection(backwards)
+	return r
+}
+
+// reads a match distance, of which the number of bits is given by v
+func (br *bitReader) readDistance(v uint32, backwards bool) uint32 {
+	var r uint32
+	if v < 0xF0 {
+		n := int(v>>4) + 4
+		w := bits.RotateLeft32(br.bits|1, n)
+		br.bitpos += n
+		m := uint32(2)<<uint(n) - 1
+		br.bits = w &^ m
+		r = (w&m)<<4 + v&0xF - 248
+	} else {
+		n := int(v-0xF0) + 4
+		w := bits.RotateLeft32(br.bits|1, n)
+		br.bitpos += n
+		m := uint32(2)<<uint(n) - 1
+		br.bits = w &^ m
+		r = 8322816 + (w&m)<<12
+		br.refillDirection(backwards)
+		r += br.bits >> 20
+		br.bitpos += 12
+		br.bits <<= 12
+	}
+	br.refillDirection(backwards)
+	return r
+}
+
+// reads a match or literal length that does not fit in the length stream
+func (br *bitReader) readLength(backwards bool) (uint32, error) {
+	n := bits.LeadingZeros32(br.bits)
+	if n > 12 {
+		return 0, errOodleCorrupt
+	}
+	br.bitpos += n
+	br.bits <<= uint(n)
+	br.refillDirection(backwards)
+	n += 7
+	br.bitpos += n
+	r := br.bits>>uint(32-n) - 64
+	br.bits <<= uint(n)
+	br.refillDirection(backwards)
+	return r, nil
+}
+
+// reads the number of fluff symbols of a code length table
+func (br *bitReader) readFluff(numSymbols int) int {
+	if numSymbols == 256 {
+		return 0
+	}
+	x := 257 - numSymbols
+	if x > numSymbols {
+		x = numSymbols
+	}
+	x *= 2
+	y := bits.Len32(uint32(x - 1))
+	v := int(br.bits >> uint(32-y))
+	z := 1<<uint(y) - x
+	if v>>1 >= z {
+		br.bits <<= uint(y)
+		br.bitpos += y
+		return v - z
+	}
+	br.bits <<= uint(y - 1)
+	br.bitpos += y - 1
+	return v >> 1
+}
+
+// The riceReader reads bits one at a time, for the Golomb-Rice coded code lengths.
+type riceReader struct {
+	src    []byte
+	p      int
+	pEnd   int
+	bitpos int // number of bits of src[p] that have been read
+}
+
+func riceReaderAt(br *bitReader) *riceReader {
+	return &riceReader{
+		src:    br.src,
+		p:      br.p - (24-br.bitpos+7)>>3,
+		pEnd:   br.pEnd,
+		bitpos: (br.bitpos - 24) & 7,
+	}
+}
+
+// reads len(dst) unary coded values
+func (rr *riceReader) readLengths(dst []uint8) error {
+	if rr.p >= rr.pEnd {
+		return errOodleCorrupt
+	}
+	count := 0
+	for i := 0; i < len(dst); {
+		if rr.p >= rr.pEnd {
+			return errOodleCorrupt
+		}
+		bit := rr.src[rr.p] >> uint(7-rr.bitpos) & 1
+		rr.bitpos++
+		if rr.bitpos == 8 {
+			rr.bitpos = 0
+			rr.p++
+		}
+		if bit == 0 {
+			count++
+		} else {
+			dst[i] = uint8(count)
+			count = 0
+			i++
+		}
+	}
+	return nil
+}
+
+// appends n bits to each value
+func (rr *riceReader) readBits(dst []uint8, n int) error {
+	if n == 0 {
+		return nil
+	}
+	required := rr.bitpos + n*len(dst)
+	if (required+7)>>3 > rr.pEnd-rr.p {
+		return errOodleCorrupt
+	}
+	pos := rr.p*8 + rr.bitpos
+	for i := range dst {
+		v := dst[i]
+		for k := 0; k < n; k++ {
+			v = v<<1 | rr.src[pos>>3]>>uint(7-pos&7)&1
+			pos++
+		}
+		dst[i] = v
+	}
+	rr.p += required >> 3
+	rr.bitpos = required & 7
+	return nil
+}
+
+/* Huffman */
+
+// the offsets of the symbols of each code length, in the sorted list of symbols
+var huffCodePrefix = [12]uint32{0x0, 0x0, 0x2, 0x6, 0xE, 0x1E, 0x3E, 0x7E, 0xFE, 0x1FE, 0x2FE, 0x3FE}
+
+// maps the next 11 bits of a stream (least significant bit first) to the code length and the symbol
+type huffLut struct {
+	bits2len [2048]uint8
+	bits2sym [2048]uint8
+}
+
+type huffRange struct {
+	symbol int
+	num    int
+}
+
+func zigzag(v int) int {
+	return -(v & 1) ^ (v >> 1)
+}
+
+func huffReadCodeLengthsOld(br *bitReader, syms []uint8, codePrefix *[12]uint32) (int, error) {
+	if br.readBitNoRefill() == 0 {
+		// a few symbols, with their code length
+		numSymbols := int(br.readBitsNoRefill(8))
+		if numSymbols == 0 {
+			return 0, errOodleCorrupt
+		}
+		if numSymbols == 1 {
+			syms[0] = uint8(br.readBitsNoRefill(8))
+			return 1, nil
+		}
+		codelenBits := int(br.readBitsNoRefill(3))
+		if codelenBits > 4 {
+			return 0, errOodleCorrupt
+		}
+		for i := 0; i < numSymbols; i++ {
+			br.refill()
+			sym := uint8(br.readBitsNoRefill(8))
+			codelen := int(br.readBitsNoRefill(codelenBits)) + 1
+			if codelen > 11 {
+				return 0, errOodleCorrupt
+			}
+			syms[codePrefix[codelen]] = sym
+			codePrefix[codelen]++
+		}
+		return numSymbols, nil
+	}
+	// runs of symbols, the code lengths are gamma coded relative to the average
+	sym, numSymbols := 0, 0
+	avgBitsX4 := 32
+	forcedBits := int(br.readBitsNoRefill(2))
+	threshold := uint32(1) << uint(31-(20>>uint(forcedBits)))
+	skipInitialZeros := br.readBit() != 0
+	for {
+		if !skipInitialZeros {
+			if br.bits&0xff000000 == 0 {
+				return 0, errOodleCorrupt
+			}
+			sym += int(br.readBitsNoRefill(2*(bits.LeadingZeros32(br.bits)+1))) - 2 + 1
+			if sym >= 256 {
+				break
+			}
+		}
+		skipInitialZeros = false
+		br.refill()
+		if br.bits&0xff000000 == 0 {
+			return 0, errOodleCorrupt
+		}
+		n := int(br.readBitsNoRefill(2*(bits.LeadingZeros32(br.bits)+1))) - 2 + 1
+		if sym+n > 256 {
+			return 0, errOodleCorrupt
+		}
+		br.refill()
+		numSymbols += n
+		for ; n > 0; n-- {
+			if br.bits < threshold {
+				return 0, errOodleCorrupt
+			}
+			lz := bits.LeadingZeros32(br.bits)
+			v := int(br.readBitsNoRefill(lz+forcedBits+1)) + (lz-1)<<uint(forcedBits)
+			codelen := zigzag(v) + (avgBitsX4+2)>>2
+			if codelen < 1 || codelen > 11 {
+				return 0, errOodleCorrupt
+			}
+			avgBitsX4 = codelen + (3*avgBitsX4+2)>>2
+			br.refill()
+			syms[codePrefix[codelen]] = uint8(sym)
+			codePrefix[codelen]++
+			sym++
+		}
+		if sym == 256 {
+			break
+		}
+	}
+	if sym != 256 || numSymbols < 2 {
+		return 0, errOodleCorrupt
+	}
+	return numSymbols, nil
+}
+
+func huffReadCodeLengthsNew(br *bitReader, syms []uint8, codePrefix *[12]uint32) (int, error) {
+	forcedBits := int(br.readBitsNoRefill(2))
+	numSymbols := int(br.readBitsNoRefill(8)) + 1
+	fluff := br.readFluff(numSymbols)
+	codeLen := make([]uint8, numSymbols+fluff)
+	rr := riceReaderAt(br)
+	if err := rr.readLengths(codeLen); err != nil {
+		return 0, err
+	}
+	if err := rr.readBits(codeLen[:numSymbols], forcedBits); err != nil {
+		return 0, err
+	}
+	br.resetAt(rr.p, rr.bitpos)
+
+	runningSum := 0x1e
+	for i := 0; i < numSymbols; i++ {
+		v := zigzag(int(codeLen[i]))
+		l := v + runningSum>>2 + 1
+		if l < 1 || l > 11 {
+			return 0, errOodleCorrupt
+		}
+		codeLen[i] = uint8(l)
+		runningSum += v
+	}
+	ranges, err := huffConvertToRanges(br, numSymbols, fluff, codeLen[numSymbols:])
+	if err != nil {
+		return 0, err
+	}
+	i := 0
+	for _, r := range ranges {
+		for sym := r.symbol; sym < r.symbol+r.num; sym++ {
+			syms[codePrefix[codeLen[i]]] = uint8(sym)
+			codePrefix[codeLen[i]]++
+			i++
+		}
+	}
+	return numSymbols, nil
+}
+
+// reads which symbols are used, as ranges of used symbols and the space between them
+func huffConvertToRanges(br *bitReader, numSymbols int, fluff int, symlen []uint8) ([]huffRange, error) {
+	numRanges := fluff >> 1
+	symIdx := 0
+	if fluff&1 != 0 {
+		br.refill()
+		v := int(symlen[0])
+		symlen = symlen[1:]
+		if v >= 8 {
+			return nil, errOodleCorrupt
+		}
+		symIdx = int(br.readBitsNoRefill(v+1)) + 1<<uint(v+1) - 1
+	}
+	symsUsed := 0
+	ranges := make([]huffRange, 0, numRanges+1)
+	for i := 0; i < numRanges; i++ {
+		br.refill()
+		v := int(symlen[0])
+		if v >= 9 {
+			return nil, errOodleCorrupt
+		}
+		num := int(br.readBitsNoRefill(v)) + 1<<uint(v)
+		v = int(symlen[1])
+		if v >= 8 {
+			return nil, errOodleCorrupt
+		}
+		space := int(br.readBitsNoRefill(v+1)) + 1<<uint(v+1) - 1
+		ranges = append(ranges, huffRange{symbol: symIdx, num: num})
+		symsUsed += num
+		symIdx += num + space
+		symlen = symlen[2:]
+	}
+	if symIdx >= 256 || symsUsed >= numSymbols || symIdx+numSymbols-symsUsed > 256 {
+		return nil, errOodleCorrupt
+	}
+	ranges = append(ranges, huffRange{symbol: symIdx, num: numSymbols - symsUsed})
+	return ranges, nil
+}
+
+// builds the lookup table of the canonical code, where shorter codes come first
+func huffMakeLut(codePrefix *[12]uint32, syms []uint8) (*huffLut, error) {
+	var lens, symbols [2048]uint8
+	slot := uint32(0)
+	for i := uint32(1); i <= 11; i++ {
+		start := huffCodePrefix[i]
+		count := codePrefix[i] - start
+		step := uint32(1) << (11 - i)
+		if slot+count*step > 2048 {
+			return nil, errOodleCorrupt
+		}
+		for j := uint32(0); j < count; j++ {
+			for k := uint32(0); k < step; k++ {
+				lens[slot] = uint8(i)
+				symbols[slot] = syms[start+j]
+				slot++
+			}
+		}
+	}
+	if slot != 2048 {
+		return nil, errOodleCorrupt
+	}
+	// the codes are read with the least significant bit first
+	lut := &huffLut{}
+	for i := 0; i < 2048; i++ {
+		r := bits.Reverse16(uint16(i)) >> 5
+		lut.bits2len[r] = lens[i]
+		lut.bits2sym[r] = symbols[i]
+	}
+	return lut, nil
+}
+
+// decodes Huffman coded data, which is split in 3 streams (typ 1) or twice 3 streams (typ 2)
+func decodeHuffman(src []byte, dst []byte, typ int) (int, error) {
+	br := newBitReader(src, 0, len(src))
+	codePrefix := huffCodePrefix
+	var syms [1280]uint8
+	var numSyms int
+	var err error
+	if br.readBitNoRefill() == 0 {
+		numSyms, err = huffReadCodeLengthsOld(br, syms[:], &codePrefix)
+	} else if br.readBitNoRefill() == 0 {
+		numSyms, err = huffReadCodeLengthsNew(br, syms[:], &codePrefix)
+	} else {
+		err = errOodleCorrupt
+	}
+	if err != nil {
+		return 0, err
+	}
+	if numSyms < 1 {
+		return 0, errOodleCorrupt
+	}
+	pos := br.bytePos()
+	if pos > len(src) {
+		return 0, errOodleCorrupt
+	}
+	if numSyms == 1 {
+		for i := range dst {
+			dst[i] = syms[0]
+		}
+		return pos, nil
+	}
+	lut, err := huffMakeLut(&codePrefix, syms[:])
+	if err != nil {
+		return 0, err
+	}
+
+	if typ == 1 {
+		if pos+3 > len(src) {
+			return 0, errOodleCorrupt
+		}
+		splitMid := int(binary.LittleEndian.Uint16(src[pos:]))
+		pos += 2
+		if err := decodeHuffmanStreams(src, pos, pos+splitMid, len(src), dst, lut); err != nil {
+			return 0, err
+		}
+		return len(src), nil
+	}
+	if pos+6 > len(src) {
+		return 0, errOodleCorrupt
+	}
+	half := (len(dst) + 1) >> 1
+	splitMid := int(src[pos]) | int(src[pos+1])<<8 | int(src[pos+2])<<16
+	pos += 3
+	if splitMid > len(src)-pos {
+		return 0, errOodleCorrupt
+	}
+	mid := pos + splitMid
+	splitLeft := int(binary.LittleEndian.Uint16(src[pos:]))
+	pos += 2
+	if mid-pos < splitLeft+2 || len(src)-mid < 3 {
+		return 0, errOodleCorrupt
+	}
+	splitRight := int(binary.LittleEndian.Uint16(src[mid:]))
+	if len(src)-(mid+2) < splitRight+2 {
+		return 0, errOodleCorrupt
+	}
+	if err := decodeHuffmanStreams(src, pos, pos+splitLeft, mid, dst[:half], lut); err != nil {
+		return 0, err
+	}
+	if err := decodeHuffmanStreams(src, mid+2, mid+2+splitRight, len(src), dst[half:], lut); err != nil {
+		return 0, err
+	}
+	return len(src), nil
+}
+
+// Three streams are decoded in turn: the first from start to mid, the second backwards from end
+// and the third from mid, until it meets the second one. The bits of each stream are read with
+// the least significant bit first.
+func decodeHuffmanStreams(src []byte, start int, mid int, end int, dst []byte, lut *huffLut) error {
+	if start > mid || mid > end {
+		return errOodleCorrupt
+	}
+	peek := func(bitPos int, backwards bool) uint32 {
+		var v uint32
+		for k := 0; k < 3; k++ {
+			i := start + bitPos>>3 + k
+			if backwards {
+				i = end - 1 - bitPos>>3 - k
+			}
+			if i >= 0 && i < len(src) {
+				v |= uint32(src[i]) << uint(8*k)
+			}
+		}
+		return v >> uint(bitPos&7) & 0x7FF
+	}
+	var bitsA, bitsB, bitsC int // the number of bits that were read from each stream
+	for i := range dst {
+		switch i % 3 {
+		case 0:
+			k := peek(bitsA, false)
+			dst[i] = lut.bits2sym[k]
+			bitsA += int(lut.bits2len[k])
+			if start+(bitsA+7)>>3 > mid {
+				return errOodleCorrupt
+			}
+		case 1:
+			k := peek(bitsB, true)
+			dst[i] = lut.bits2sym[k]
+			bitsB += int(lut.bits2len[k])
+		case 2:
+			k := uint32(0)
+			for b := 0; b < 3; b++ {
+				if j := mid + bitsC>>3 + b; j < len(src) {
+					k |= uint32(src[j]) << uint(8*b)
+				}
+			}
+			k = k >> uint(bitsC&7) & 0x7FF
+			dst[i] = lut.bits2sym[k]
+			bitsC += int(lut.bits2len[k])
+		}
+		if mid+(bitsC+7)>>3 > end-(bitsB+7)>>3 {
+			return errOodleCorrupt
+		}
+	}
+	if start+(bitsA+7)>>3 != mid || mid+(bitsC+7)>>3 != end-(bitsB+7)>>3 {
+		return errOodleCorrupt
+	}
+	return nil
+}
+
+/* tANS */
+
+type tansData struct {
+	a []uint8  // symbols with weight 1
+	b []uint32 // symbol << 16 | weight, for the other symbols
+}
+
+type tansLutEnt struct {
+	x      uint32
+	bitsX  uint8
+	symbol uint8
+	w      uint32
+}
+
+func tansDecodeTable(br *bitReader, lBits int) (*tansData, error) {
+	td := &tansData{}
+	br.refill()
+	L := 1 << uint(lBits)
+	if br.readBitNoRefill() != 0 {
+		// the weights are Golomb-Rice coded
+		q := int(br.readBitsNoRefill(3))
+		numSymbols := int(br.readBitsNoRefill(8)) + 1
+		if numSymbols < 2 {
+			return nil, errOodleCorrupt
+		}
+		fluff := br.readFluff(numSymbols)
+		rice := make([]uint8, numSymbols+fluff)
+		rr := riceReaderAt(br)
+		if err := rr.readLengths(rice); err != nil {
+			return nil, err
+		}
+		br.resetAt(rr.p, rr.bitpos)
+		ranges, err := huffConvertToRanges(br, numSymbols, fluff, rice[numSymbols:])
+		if err != nil {
+			return nil, err
+		}
+		br.refill()
+		average := 6
+		sum := 0
+		i := 0
+		for _, r := range ranges {
+			for symbol := r.symbol; symbol < r.symbol+r.num; symbol++ {
+				br.refill()
+				nextra := q + int(rice[i])
+				i++
+				if nextra > 15 {
+					return nil, errOodleCorrupt
+				}
+				v := int(br.readBitsNoRefill(nextra)) + 1<<uint(nextra) - 1<<uint(q)
+				averageDiv4 := average >> 2
+				limit := 2 * averageDiv4
+				if v <= limit {
+					v = averageDiv4 + zigzag(v)
+				}
+				if limit > v {
+					limit = v
+				}
+				v++
+				average += limit - averageDiv4
+				if v == 1 {
+					td.a = append(td.a, uint8(symbol))
+				} else {
+					td.b = append(td.b, uint32(symbol)<<16+uint32(v))
+				}
+				sum += v
+			}
+		}
+		if sum != L {
+			return nil, errOodleCorrupt
+		}
+		return td, nil
+	}
+	// a few symbols with their weights
+	var seen [256]bool
+	count := int(br.readBitsNoRefill(3)) + 1
+	bitsPerSym := bits.Len(uint(lBits))
+	maxDeltaBits := int(br.readBitsNoRefill(bitsPerSym))
+	if maxDeltaBits == 0 || maxDeltaBits > lBits {
+		return nil, errOodleCorrupt
+	}
+	weight, total := 0, 0
+	for ; count > 0; count-- {
+		br.refill()
+		sym := int(br.readBitsNoRefill(8))
+		if seen[sym] {
+			return nil, errOodleCorrupt
+		}
+		weight += int(br.readBitsNoRefill(maxDeltaBits))
+		if weight == 0 {
+			return nil, errOodleCorrupt
+		}
+		seen[sym] = true
+		if weight == 1 {
+			td.a = append(td.a, uint8(sym))
+		} else {
+			td.b = append(td.b, uint32(sym)<<16+uint32(weight))
+		}
+		total += weight
+	}
+	br.refill()
+	sym := int(br.readBitsNoRefill(8))
+	if seen[sym] {
+		return nil, errOodleCorrupt
+	}
+	if L-total < weight || L-total <= 1 {
+		return nil, errOodleCorrupt
+	}
+	td.b = append(td.b, uint32(sym)<<16+uint32(L-total))
+	sort.Slice(td.a, func(i, j int) bool { return td.a[i] < td.a[j] })
+	sort.Slice(td.b, func(i, j int) bool { return td.b[i] < td.b[j] })
+	return td, nil
+}
+
+// spreads the symbols over the states; the states are filled in four interleaved parts
+func tansInitLut(td *tansData, lBits int) ([]tansLutEnt, error) {
+	L := 1 << uint(lBits)
+	lut := make([]tansLutEnt, L)
+	slotsLeft := L - len(td.a)
+	if slotsLeft < 0 {
+		return nil, errOodleCorrupt
+	}
+	sa := slotsLeft >> 2
+	var pointers [4]int
+	sb := 0
+	for j := 1; j < 4; j++ {
+		sb += sa
+		if slotsLeft&3 > j-1 {
+			sb++
+		}
+		pointers[j] = sb
+	}
+	// the symbols with weight 1 come last
+	for i, sym := range td.a {
+		lut[slotsLeft+i] = tansLutEnt{x: uint32(L - 1), bitsX: uint8(lBits), symbol: sym}
+	}
+	put := func(j int, e tansLutEnt) error {
+		if pointers[j] >= slotsLeft {
+			return errOodleCorrupt
+		}
+		lut[pointers[j]] = e
+		pointers[j]++
+		return nil
+	}
+	weightsSum := 0
+	for _, entry := range td.b {
+		weight := int(entry & 0xffff)
+		symbol := uint8(entry >> 16)
+		if weight > 4 {
+			symBits := bits.Len(uint(weight)) - 1
+			z := lBits - symBits
+			le := tansLutEnt{symbol: symbol, bitsX: uint8(z), x: uint32(1)<<uint(z) - 1, w: uint32((L - 1) & (weight << uint(z)))}
+			whatToAdd := uint32(1) << uint(z)
+			x := 1<<uint(symBits+1) - weight
+			for j := 0; j < 4; j++ {
+				y := (weight + (weightsSum-j-1)&3) >> 2
+				if x >= y {
+					for n := y; n > 0; n-- {
+						if err := put(j, le); err != nil {
+							return nil, err
+						}
+						le.w += whatToAdd
+					}
+					x -= y
+				} else {
+					for n := x; n > 0; n-- {
+						if err := put(j, le); err != nil {
+							return nil, err
+						}
+						le.w += whatToAdd
+					}
+					z--
+					whatToAdd >>= 1
+					le.bitsX = uint8(z)
+					le.w = 0
+					le.x >>= 1
+					for n := y - x; n > 0; n-- {
+						if err := put(j, le); err != nil {
+							return nil, err
+						}
+						le.w += whatToAdd
+					}
+					x = weight
+				}
+			}
+		} else {
+			b := uint32(1<<uint(weight)-1) << uint(weightsSum&3)
+			b |= b >> 4
+			ww := weight
+			for n := weight; n > 0; n-- {
+				idx := bits.TrailingZeros32(b)
+				b &= b - 1
+				weightBits := bits.Len(uint(ww)) - 1
+				e := tansLutEnt{
+					symbol: symbol,
+					bitsX:  uint8(lBits - weightBits),
+					x:      uint32(1)<<uint(lBits-weightBits) - 1,
+					w:      uint32((L - 1) & (ww << uint(lBits-weightBits))),
+				}
+				if err := put(idx, e); err != nil {
+					return nil, err
+				}
+				ww++
+			}
+		}
+		weightsSum += weight
+	}
+	return lut, nil
+}
+
+// lsbStream reads bits with the least significant bit first, forwards or backwards through the bytes
+type lsbStream struct {
+	src       []byte
+	start     int // forwards: the first byte; backwards: the byte after the last one
+	backwards bool
+	bitPos    int
+}
+
+func (s *lsbStream) read(n int) uint32 {
+	var v uint64
+	for k := 0; k < 4; k++ {
+		i := s.start + s.bitPos>>3 + k
+		if s.backwards {
+			i = s.start - 1 - s.bitPos>>3 - k
+		}
+		if i >= 0 && i < len(s.src) {
+			v |= uint64(s.src[i]) << uint(8*k)
+		}
+	}
+	v >>= uint(s.bitPos & 7)
+	s.bitPos += n
+	return uint32(v) & (uint32(1)<<uint(n) - 1)
+}
+
+// the number of bytes of which bits were read
+func (s *lsbStream) bytesUsed() int {
+	return (s.bitPos + 7) >> 3
+}
+
+func decodeTans(src []byte, dst []byte) (int, error) {
+	if len(src) < 8 || len(dst) < 5 {
+		return 0, errOodleCorrupt
+	}
+	br := newBitReader(src, 0, len(src))
+	if br.readBitNoRefill() != 0 {
+		return 0, errOodleCorrupt // reserved
+	}
+	lBits := int(br.readBitsNoRefill(2)) + 8
+	td, err := tansDecodeTable(br, lBits)
+	if err != nil {
+		return 0, err
+	}
+	pos := br.bytePos()
+	if pos >= len(src) {
+		return 0, errOodleCorrupt
+	}
+	lut, err := tansInitLut(td, lBits)
+	if err != nil {
+		return 0, err
+	}
+	// five states are used in turn, with a stream that is read forwards and one that is read backwards
+	f := &lsbStream{src: src, start: pos}
+	b := &lsbStream{src: src, start: len(src), backwards: true}
+	var states [5]uint32
+	states[0] = f.read(lBits)
+	states[1] = b.read(lBits)
+	states[2] = f.read(lBits)
+	states[3] = b.read(lBits)
+	states[4] = f.read(lBits)
+
+	n := len(dst) - 5
+	i := 0
+	for i < n {
+		for _, s := range []*lsbStream{f, b} {
+			for k := 0; k < 5 && i < n; k++ {
+				e := &lut[states[k]]
+				dst[i] = e.symbol
+				i++
+				states[k] = s.read(int(e.bitsX))&e.x + e.w
+				if states[k] >= uint32(len(lut)) {
+					return 0, errOodleCorrupt
+				}
+			}
+		}
+	}
+	if pos+f.bytesUsed() != len(src)-b.bytesUsed() {
+		return 0, errOodleCorrupt
+	}
+	// the final states are the last five bytes
+	for k, state := range states {
+		if state > 0xFF {
+			return 0, errOodleCorrupt
+		}
+		dst[n+k] = uint8(state)
+	}
+	return len(src), nil
+}
+
+/* Other entropy coded blocks */
+
+// decodes run length encoded data; the commands are read from the end, the literal bytes from the start
+func decodeRLE(src []byte, dst []byte) (int, error) {
+	if len(src) <= 1 {
+		if len(src) != 1 {
+			return 0, errOodleCorrupt
+		}
+		for i := range dst {
+			dst[i] = src[0]
+		}
+		return 1, nil
+	}
+	cmds := src[1:]
+	if src[0] != 0 {
+		// the start of the commands is entropy coded
+		decoded, n, err := decodeOodleBytes(src, 0x40000)
+		if err != nil {
+			return 0, err
+		}
+		cmds = append(append([]byte{}, decoded...), src[n:]...)
+	}
+	d := 0
+	p, pEnd := 0, len(cmds)
+	rleByte := byte(0)
+	copyLiterals := func(n int) error {
+		if pEnd-p < n || len(dst)-d < n {
+			return errOodleCorrupt
+		}
+		copy(dst[d:], cmds[p:p+n])
+		p += n
+		d += n
+		return nil
+	}
+	fill := func(n int) error {
+		if len(dst)-d < n {
+			return errOodleCorrupt
+		}
+		for i := 0; i < n; i++ {
+			dst[d+i] = rleByte
+		}
+		d += n
+		return nil
+	}
+	word := func() (int, error) {
+		if pEnd-p < 2 {
+			return 0, errOodleCorrupt
+		}
+		pEnd -= 2
+		return int(binary.LittleEndian.Uint16(cmds[pEnd:])), nil
+	}
+	for p < pEnd {
+		cmd := int(cmds[pEnd-1])
+		var err error
+		switch {
+		case cmd == 0 || cmd >= 0x30:
+			pEnd--
+			toCopy := ^cmd & 0xF
+			toRLE := cmd >> 4
+			if pEnd-p < toCopy || len(dst)-d < toCopy+toRLE {
+				return 0, errOodleCorrupt
+			}
+			if err = copyLiterals(toCopy); err == nil {
+				err = fill(toRLE)
+			}
+		case cmd >= 0x10:
+			var v int
+			if v, err = word(); err == nil {
+				v -= 4096
+				if err = copyLiterals(v & 0x3F); err == nil {
+					err = fill(v >> 6)
+				}
+			}
+		case cmd == 1:
+			rleByte = cmds[p]
+			p++
+			pEnd--
+		case cmd >= 9:
+			var v int
+			if v, err = word(); err == nil {
+				err = fill((v - 0x8ff) * 128)
+			}
+		default:
+			var v int
+			if v, err = word(); err == nil {
+				err = copyLiterals((v - 511) * 64)
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if p != pEnd || d != len(dst) {
+		return 0, errOodleCorrupt
+	}
+	return len(src), nil
+}
+
+// decodes data that is split in several entropy coded blocks
+func decodeRecursive(src []byte, dst []byte) (int, error) {
+	if len(src) < 6 {
+		return 0, errOodleCorrupt
+	}
+	n := int(src[0] & 0x7f)
+	if n < 2 {
+		return 0, errOodleCorrupt
+	}
+	if src[0]&0x80 == 0 {
+		pos, d := 1, 0
+		for ; n > 0; n-- {
+			out, used, err := decodeOodleBytes(src[pos:], len(dst)-d)
+			if err != nil {
+				return 0, err
+			}
+			copy(dst[d:], out)
+			d += len(out)
+			pos += used
+		}
+		if d != len(dst) {
+			return 0, errOodleCorrupt
+		}
+		return pos, nil
+	}
+	arrays, used, err := decodeMultiArray(src, len(dst), 1)
+	if err != nil {
+		return 0, err
+	}
+	if len(arrays[0]) != len(dst) {
+		return 0, errOodleCorrupt
+	}
+	copy(dst, arrays[0])
+	return used, nil
+}
+
+// decodeMultiArray decodes arrayCount arrays, which are built from intervals of a number of entropy coded blocks.
+func decodeMultiArray(src []byte, capacity int, arrayCount int) ([][]byte, int, error) {
+	if len(src) < 4 {
+		return nil, 0, errOodleCorrupt
+	}
+	numArrays := int(src[0])
+	if numArrays&0x80 == 0 {
+		return nil, 0, errOodleCorrupt
+	}
+	numArrays &= 0x3f
+	pos := 1
+	arrays := make([][]byte, arrayCount)
+
+	if numArrays == 0 {
+		total := 0
+		for i := range arrays {
+			out, n, err := decodeOodleBytes(src[pos:], capacity-total)
+			if err != nil {
+				return nil, 0, err
+			}
+			arrays[i] = out
+			total += len(out)
+			pos += n
+		}
+		return arrays, pos, nil
+	}
+
+	entropyArrays := make([][]byte, numArrays)
+	total := 0
+	for i := range entropyArrays {
+		out, n, err := decodeOodleBytes(src[pos:], capacity-total)
+		if err != nil {
+			return nil, 0, err
+		}
+		entropyArrays[i] = out
+		total += len(out)
+		pos += n
+	}
+	if len(src)-pos < 3 {
+		return nil, 0, errOodleCorrupt
+	}
+	q := int(binary.LittleEndian.Uint16(src[pos:]))
+	pos += 2
+	numIndexes, err := oodleBlockSize(src[pos:], total)
+	if err != nil {
+		return nil, 0, err
+	}
+	numLens := numIndexes - arrayCount
+	if numLens < 1 {
+		return nil, 0, errOodleCorrupt
+	}
+
+	var indexes, lenLog2 []byte
+	if q&0x8000 != 0 {
+		// the index and the length are combined in one byte
+		out, n, err := decodeOodleBytes(src[pos:], numIndexes)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(out) != numIndexes {
+			return nil, 0, errOodleCorrupt
+		}
+		pos += n
+		indexes = make([]byte, numIndexes)
+		lenLog2 = make([]byte, numIndexes)
+		for i, t := range out {
+			lenLog2[i] = t >> 4
+			indexes[i] = t & 0xF
+		}
+		numLens = numIndexes
+	} else {
+		out, n, err := decodeOodleBytes(src[pos:], numIndexes)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(out) != numIndexes {
+			return nil, 0, errOodleCorrupt
+		}
+		pos += n
+		indexes = out
+		out, n, err = decodeOodleBytes(src[pos:], numLens)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(out) != numLens {
+			return nil, 0, errOodleCorrupt
+		}
+		pos += n
+		lenLog2 = out
+		for _, l := range lenLog2 {
+			if l > 16 {
+				return nil, 0, errOodleCorrupt
+			}
+		}
+	}
+
+	// the lengths of the intervals are read alternately from the start and from the end of the bits;
+	// a length has lenLog2 bits, after an implicit 1 bit.
+	varbitsLen := q & 0x3FFF
+	if len(src)-pos < varbitsLen {
+		return nil, 0, errOodleCorrupt
+	}
+	varbits := src[pos : pos+varbitsLen]
+	readVarbits := func(bitPos int, n int, backwards bool) int {
+		v := 1
+		for k := 0; k < n; k++ {
+			i := (bitPos + k) >> 3
+			if backwards {
+				i = len(varbits) - 1 - i
+			}
+			bit := 0
+			if i >= 0 && i < len(varbits) {
+				bit = int(varbits[i]>>uint(7-(bitPos+k)&7)) & 1
+			}
+			v = v<<1 | bit
+		}
+		return v
+	}
+	intervals := make([]int, numLens)
+	bitsF, bitsB := 0, 0
+	for i := range intervals {
+		n := int(lenLog2[i])
+		if i&1 == 0 {
+			intervals[i] = readVarbits(bitsF, n, false)
+			bitsF += n
+		} else {
+			intervals[i] = readVarbits(bitsB, n, true)
+			bitsB += n
+		}
+	}
+
+	if indexes[numIndexes-1] != 0 {
+		return nil, 0, errOodleCorrupt
+	}
+	out := make([]byte, 0, total)
+	indi, leni := 0, 0
+	for i := range arrays {
+		start := len(out)
+		for {
+			if indi >= numIndexes {
+				return nil, 0, errOodleCorrupt
+			}
+			source := int(indexes[indi])
+			indi++
+			if source == 0 {
+				break
+			}
+			if source > numArrays || leni >= numLens {
+				return nil, 0, errOodleCorrupt
+			}
+			curLen := intervals[leni]
+			leni++
+			if curLen > len(entropyArrays[source-1]) || len(out)+curLen > capacity {
+				return nil, 0, errOodleCorrupt
+			}
+			out = append(out, entropyArrays[source-1][:curLen]...)
+			entropyArrays[source-1] = entropyArrays[source-1][curLen:]
+		}
+		if q&0x8000 != 0 {
+			leni++
+		}
+		arrays[i] = out[start:len(out):len(out)]
+	}
+	if indi != numIndexes || leni != numLens {
+		return nil, 0, errOodleCorrupt
+	}
+	for _, a := range entropyArrays {
+		if len(a) != 0 {
+			return nil, 0, errOodleCorrupt
+		}
+	}
+	return arrays, pos + varbitsLen, nil
+}
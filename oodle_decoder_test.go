@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateOodleVectors = flag.Bool("update", false, "write the Oodle vectors in testdata/oodle with the library of "+OodleLibraryEnv)
+
+// Small compressed samples with their expected output, one for every decoder.
+// Every sample is a single LZ coded chunk of which the streams are stored, so that the
+// commands, distances and lengths of each LZ decoder can be followed by hand.
+// The samples are built from the format rather than by the Oodle encoder, so they check
+// the decoders against the format as it is described here; the vectors in testdata/oodle
+// are the ones that come from the encoder.
+var oodleSamples = []struct {
+	name       string
+	compressed string
+	expected   string
+}{
+	{
+		// mode 0: 4 commands with new and recent distances, a literal and a match length from the length stream
+		name:       "Kraken",
+		compressed: "8c060000268000246f6f646c65206b72000008f2fc0102bc0000ea8004efcd58bc0000020604800203070088",
+		expected:   "oodle kraken! oodle kraken! oodle kraken! kraken! kraken! kraken!\n",
+	},
+	{
+		// mode 0: near matches with new and recent distances, and a long run of literals
+		name:       "Mermaid",
+		compressed: "8c0a0000688000666d65726d61696420804cfc0eaef4bf01b700000703f3b30206094df8fb441200fbfc04f8bf0c0cff54fbb8d20009fa07f811f308000cb80bf90947f00200fe064e3c0006fcedffae13fcf23d52b4fdf707020248bc9580043d6ab80002000d00160000000004",
+		expected:   "mermaid is a mermaid! mermaid is a mermaid! the commands have up to 7 literals, longer runs read their length.\n",
+	},
+	{
+		// mode 1 (raw literals) with the Mermaid decoder: short near matches and a long near match
+		name:       "Selkie",
+		compressed: "8c0a00003088002e73656c6b69653a208010666173742c2065722c206573743b200a800426241d010400060008001f001f0000000009",
+		expected:   strings.Repeat("selkie: fast, faster, fastest; ", 4) + "selkie: fa\n",
+	},
+	{
+		// mode 0: scaled distances, and a match length that doesn't fit in the length stream
+		name:       "Leviathan",
+		compressed: "8c0c00002d80002b6c6576696174686180000003090129000005000002ff0000000702c7aaf5e9f5ea000004ffefef479a0040",
+		expected:   "leviathan, leviathan" + strings.Repeat("!", 265) + "\nleviathan, leviathan!\n",
+	},
+}
+
+func TestOodleDecompressSamples(t *testing.T) {
+	for _, s := range oodleSamples {
+		src, err := hex.DecodeString(s.compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst := make([]byte, len(s.expected))
+		if err := oodleDecompress(src, dst); err != nil {
+			t.Errorf("%s: %v", s.name, err)
+			continue
+		}
+		if string(dst) != s.expected {
+			t.Errorf("%s: decompressed to %q, expected %q", s.name, dst, s.expected)
+		}
+		// a truncated sample must be rejected, not decoded partially
+		if err := oodleDecompress(src[:len(src)-1], dst); err == nil {
+			t.Errorf("%s: the truncated sample was decompressed without an error", s.name)
+		}
+	}
+}
+
+// The vectors in testdata/oodle are plaintext.txt compressed by OodleLZ_Compress, one for every compressor,
+// at the level that packing uses. They are written by go test -run TestOodleDecompressVectors -update
+// when the library is given by UECASTOC_OODLE_LIB; compressors without a vector are skipped.
+func TestOodleDecompressVectors(t *testing.T) {
+	dir := filepath.Join("testdata", "oodle")
+	expected, err := os.ReadFile(filepath.Join(dir, "plaintext.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *updateOodleVectors {
+		path := os.Getenv(OodleLibraryEnv)
+		if path == "" {
+			t.Fatal("-update needs the Oodle library; set " + OodleLibraryEnv)
+		}
+		lib, err := loadOodleLibrary(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, compressor := range oodleCompressors {
+			compressed, err := lib.compress(expected, compressor, OodleLevelOptimal3)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, name+".oodle"), compressed, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for name := range oodleCompressors {
+		t.Run(name, func(t *testing.T) {
+			src, err := os.ReadFile(filepath.Join(dir, name+".oodle"))
+			if os.IsNotExist(err) {
+				t.Skip("no vector; write it with -update")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			dst := make([]byte, len(expected))
+			if err := oodleDecompress(src, dst); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(dst, expected) {
+				t.Error("the decompressed data differs from plaintext.txt")
+			}
+		})
+	}
+}
+
+// Compares the decoder with the Oodle library, which is only done when the library is given by UECASTOC_OODLE_LIB.
+func TestOodleDecompressLibrary(t *testing.T) {
+	path := os.Getenv(OodleLibraryEnv)
+	if path == "" {
+		t.Skip(OodleLibraryEnv + " is not set")
+	}
+	lib, err := loadOodleLibrary(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// more than a quantum of text with repetitions, followed by random data
+	var data bytes.Buffer
+	rng := rand.New(rand.NewSource(1))
+	words := strings.Fields("the quick brown fox jumps over the lazy dog while packing chunks into a container")
+	for data.Len() < 0x50000 {
+		data.WriteString(words[rng.Intn(len(words))])
+		data.WriteByte(' ')
+	}
+	noise := make([]byte, 0x8000)
+	rng.Read(noise)
+	data.Write(noise)
+
+	for name, compressor := range oodleCompressors {
+		compressed, err := lib.compress(data.Bytes(), compressor, OodleLevelOptimal3)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		dst := make([]byte, data.Len())
+		if err := oodleDecompress(compressed, dst); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(dst, data.Bytes()) {
+			t.Errorf("%s: the decompressed data differs from the original", name)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+//go:build !windows
+
+package main
+
+/*
+#cgo LDFLAGS: -ldl
+#include <dlfcn.h>
+#include <stdint.h>
+#include <stdlib.h>
+
+typedef intptr_t (*oodleDecompressFunc)(const void*, intptr_t, void*, intptr_t, int, int, int,
+	void*, intptr_t, void*, void*, void*, intptr_t, int);
+typedef intptr_t (*oodleCompressFunc)(int, const void*, intptr_t, void*, int,
+	const void*, const void*, const void*, void*, intptr_t);
+
+static intptr_t callOodleDecompress(void* f, const void* in, intptr_t inSize, void* out, intptr_t outSize) {
+	return ((oodleDecompressFunc)f)(in, inSize, out, outSize, 1, 0, 0, NULL, 0, NULL, NULL, NULL, 0, 3);
+}
+static intptr_t callOodleCompress(void* f, int compressor, const void* in, intptr_t inSize, void* out, int level) {
+	return ((oodleCompressFunc)f)(compressor, in, inSize, out, level, NULL, NULL, NULL, NULL, 0);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+)
+
+// names under which the Oodle library is searched by dlopen, when no path is given
+var defaultOodleLibraryNames = []string{
+	"liboo2corelinux64.so.9",
+	"liboo2corelinux64.so",
+	"liboo2core.so",
+	"liboo2coremac64.2.9.dylib",
+}
+
+// an Oodle shared library, loaded with dlopen
+type sharedOodleLibrary struct {
+	decompressFunc unsafe.Pointer
+	compressFunc   unsafe.Pointer
+}
+
+func loadOodleLibrary(path string) (oodleLibrary, error) {
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+	handle := C.dlopen(cPath, C.RTLD_NOW|C.RTLD_LOCAL)
+	if handle == nil {
+		return nil, errors.New(C.GoString(C.dlerror()))
+	}
+	lib := &sharedOodleLibrary{}
+	for name, f := range map[string]*unsafe.Pointer{
+		"OodleLZ_Decompress": &lib.decompressFunc,
+		"OodleLZ_Compress":   &lib.compressFunc,
+	} {
+		cName := C.CString(name)
+		*f = C.dlsym(handle, cName)
+		C.free(unsafe.Pointer(cName))
+		if *f == nil {
+			C.dlclose(handle)
+			return nil, errors.New(name + " was not found in the library")
+		}
+	}
+	return lib, nil
+}
+
+func loadDefaultOodleLibrary() (oodleLibrary, error) {
+	for _, name := range defaultOodleLibraryNames {
+		if lib, err := loadOodleLibrary(name); err == nil {
+			return lib, nil
+		}
+	}
+	return nil, errors.New("the Oodle library was not found")
+}
+
+func downloadOodleLibrary() error {
+	return errors.New("the Oodle library can't be downloaded on this platform")
+}
+
+func (lib *sharedOodleLibrary) decompress(in []byte, outputSize int) ([]byte, error) {
+	if len(in) == 0 || outputSize == 0 {
+		return nil, errors.New("oodle: nothing to decompress")
+	}
+	output := make([]byte, outputSize)
+	n := C.callOodleDecompress(lib.decompressFunc, unsafe.Pointer(&in[0]), C.intptr_t(len(in)),
+		unsafe.Pointer(&output[0]), C.intptr_t(outputSize))
+	if int(n) != outputSize {
+		return nil, errors.New("oodle did not decompress correctly")
+	}
+	return output, nil
+}
+
+func (lib *sharedOodleLibrary) compress(in []byte, compressor int, level int) ([]byte, error) {
+	if len(in) == 0 {
+		return []byte{}, nil
+	}
+	output := make([]byte, oodleCompressBound(len(in)))
+	n := C.callOodleCompress(lib.compressFunc, C.int(compressor), unsafe.Pointer(&in[0]), C.intptr_t(len(in)),
+		unsafe.Pointer(&output[0]), C.int(level))
+	if n <= 0 {
+		return nil, errors.New("oodle compression failed")
+	}
+	return output[:n], nil
+}
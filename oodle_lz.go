@@ -0,0 +1,709 @@
+package main
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// The LZ decoders of Kraken, Mermaid (and Selkie) and Leviathan, see oodle_decoder.go.
+// A chunk of at most 128KB is decoded at a time; matches may reference all data before it.
+// The first 8 bytes of the data are always stored as they are.
+// Literals are either copied as they are (mode 1), or added to the byte at the last match distance (mode 0).
+
+// copies a match byte by byte, the source and destination may overlap
+func copyMatch(dst []byte, d int, distance int, length int) {
+	for i := 0; i < length; i++ {
+		dst[d+i] = dst[d+i+distance]
+	}
+}
+
+// copies literals; with subtract set, the literals are added to the byte at the (negative) distance
+func copyLiterals(dst []byte, d int, lits []byte, subtract bool, distance int) {
+	if !subtract {
+		copy(dst[d:], lits)
+		return
+	}
+	for i, l := range lits {
+		dst[d+i] = l + dst[d+i+distance]
+	}
+}
+
+// unpacks the match distances and the lengths that didn't fit in a byte;
+// the bits are read from the start and from the end of src at the same time
+func unpackOffsets(src []byte, packedOffs []byte, packedOffsExtra []byte, scale int, packedLens []byte) ([]int, []int, error) {
+	a := newBitReader(src, 0, len(src))
+	b := newBackwardBitReader(src, 0, len(src))
+
+	if b.bits < 0x2000 {
+		return nil, nil, errOodleCorrupt
+	}
+	n := bits.LeadingZeros32(b.bits)
+	b.bitpos += n
+	b.bits <<= uint(n)
+	b.refillBackwards()
+	n++
+	longLenCount := int(b.bits>>uint(32-n)) - 1
+	b.bitpos += n
+	b.bits <<= uint(n)
+	b.refillBackwards()
+
+	offs := make([]int, len(packedOffs))
+	for i, v := range packedOffs {
+		br := a
+		if i&1 == 1 {
+			br = b
+		}
+		if scale == 0 {
+			offs[i] = -int(br.readDistance(uint32(v), i&1 == 1))
+			continue
+		}
+		if v>>3 > 26 {
+			return nil, nil, errOodleCorrupt
+		}
+		o := uint32(8+v&7)<<(v>>3) | br.readMoreThan24Bits(int(v>>3), i&1 == 1)
+		offs[i] = 8 - int(o)
+		if scale != 1 {
+			offs[i] = scale*offs[i] - int(packedOffsExtra[i])
+		}
+	}
+
+	if longLenCount > 512 || longLenCount < 0 {
+		return nil, nil, errOodleCorrupt
+	}
+	longLens := make([]int, longLenCount)
+	for i := range longLens {
+		v, err := [2]*bitReader{a, b}[i&1].readLength(i&1 == 1)
+		if err != nil {
+			return nil, nil, err
+		}
+		longLens[i] = int(v)
+	}
+	if a.bytePos() != b.bytePosBackwards() {
+		return nil, nil, errOodleCorrupt
+	}
+
+	lens := make([]int, len(packedLens))
+	for i, v := range packedLens {
+		l := int(v)
+		if l == 255 {
+			if len(longLens) == 0 {
+				return nil, nil, errOodleCorrupt
+			}
+			l += longLens[0]
+			longLens = longLens[1:]
+		}
+		lens[i] = l + 3
+	}
+	if len(longLens) != 0 {
+		return nil, nil, errOodleCorrupt
+	}
+	return offs, lens, nil
+}
+
+// reads the distances of the matches, optionally split in a scaled part and the low bits
+func readPackedOffsets(src []byte, pos int, limit int) (packed []byte, extra []byte, scale int, n int, err error) {
+	start := pos
+	if src[pos]&0x80 != 0 {
+		scale = int(src[pos]) - 127
+		pos++
+	}
+	packed, used, err := decodeOodleBytes(src[pos:], limit)
+	if err != nil {
+		return nil, nil, 0, 0, err
+	}
+	pos += used
+	if scale > 1 {
+		extra, used, err = decodeOodleBytes(src[pos:], limit)
+		if err != nil {
+			return nil, nil, 0, 0, err
+		}
+		if len(extra) != len(packed) {
+			return nil, nil, 0, 0, errOodleCorrupt
+		}
+		pos += used
+	}
+	return packed, extra, scale, pos - start, nil
+}
+
+/* Kraken */
+
+func krakenDecodeLZ(mode int, src []byte, dst []byte, offset int, dstCount int) error {
+	if mode > 1 || len(src) < 13 {
+		return errOodleCorrupt
+	}
+	pos := 0
+	if offset == 0 {
+		copy(dst, src[:8])
+		pos = 8
+	}
+	if src[pos]&0x80 != 0 {
+		return errOodleCorrupt // excess bytes, not supported
+	}
+	lits, n, err := decodeOodleBytes(src[pos:], dstCount)
+	if err != nil {
+		return err
+	}
+	pos += n
+	cmds, n, err := decodeOodleBytes(src[pos:], dstCount)
+	if err != nil {
+		return err
+	}
+	pos += n
+	if len(src)-pos < 3 {
+		return errOodleCorrupt
+	}
+	packedOffs, packedOffsExtra, scale, n, err := readPackedOffsets(src, pos, len(cmds))
+	if err != nil {
+		return err
+	}
+	pos += n
+	packedLens, n, err := decodeOodleBytes(src[pos:], dstCount>>2)
+	if err != nil {
+		return err
+	}
+	pos += n
+	offs, lens, err := unpackOffsets(src[pos:], packedOffs, packedOffsExtra, scale, packedLens)
+	if err != nil {
+		return err
+	}
+
+	// every command has 2 bits for the number of literals, 4 bits for the length of the match and 2 bits
+	// that choose one of the 3 most recent distances, or a new distance.
+	d := offset
+	if offset == 0 {
+		d = 8
+	}
+	end := offset + dstCount
+	recent := [4]int{-8, -8, -8}
+	lastOffset := -8
+	oi, li := 0, 0
+	for _, cmd := range cmds {
+		litlen := int(cmd & 3)
+		offsIndex := int(cmd >> 6)
+		matchlen := int(cmd>>2) & 0xF
+		if litlen == 3 {
+			if li >= len(lens) {
+				return errOodleCorrupt
+			}
+			litlen = lens[li]
+			li++
+		}
+		if litlen > len(lits) || end-d < litlen {
+			return errOodleCorrupt
+		}
+		copyLiterals(dst, d, lits[:litlen], mode == 0, lastOffset)
+		d += litlen
+		lits = lits[litlen:]
+
+		if offsIndex == 3 {
+			if oi >= len(offs) {
+				return errOodleCorrupt
+			}
+			recent[3] = offs[oi]
+			oi++
+		}
+		distance := recent[offsIndex]
+		copy(recent[1:offsIndex+1], recent[:offsIndex])
+		recent[0] = distance
+		lastOffset = distance
+
+		if distance >= 0 || d+distance < 0 {
+			return errOodleCorrupt
+		}
+		if matchlen != 15 {
+			matchlen += 2
+		} else {
+			if li >= len(lens) {
+				return errOodleCorrupt
+			}
+			matchlen = 14 + lens[li]
+			li++
+		}
+		if end-d < matchlen {
+			return errOodleCorrupt
+		}
+		copyMatch(dst, d, distance, matchlen)
+		d += matchlen
+	}
+	if oi != len(offs) || li != len(lens) || end-d != len(lits) {
+		return errOodleCorrupt
+	}
+	copyLiterals(dst, d, lits, mode == 0, lastOffset)
+	return nil
+}
+
+/* Mermaid */
+
+type mermaidLzTable struct {
+	lits       []byte
+	cmds       []byte
+	cmdsSplit  int // the commands of the second 64KB start here
+	off16      []int
+	off32      [2][]int // the far distances of both 64KB halves
+	lengths    []byte
+	lengthsPos int
+}
+
+// reads the distances of the far matches, which are relative to the start of the 64KB half
+func mermaidDecodeFarOffsets(src []byte, count int, offset int) ([]int, int, error) {
+	out := make([]int, count)
+	pos := 0
+	for i := range out {
+		if len(src)-pos < 3 {
+			return nil, 0, errOodleCorrupt
+		}
+		off := int(src[pos]) | int(src[pos+1])<<8 | int(src[pos+2])<<16
+		pos += 3
+		if offset >= 0xC00000-1 && off >= 0xc00000 {
+			if pos == len(src) {
+				return nil, 0, errOodleCorrupt
+			}
+			off += int(src[pos]) << 22
+			pos++
+		}
+		if off > offset {
+			return nil, 0, errOodleCorrupt
+		}
+		out[i] = off
+	}
+	return out, pos, nil
+}
+
+func mermaidReadLzTable(mode int, src []byte, dst []byte, offset int, dstCount int) (*mermaidLzTable, error) {
+	if mode > 1 || len(src) < 10 {
+		return nil, errOodleCorrupt
+	}
+	lz := &mermaidLzTable{}
+	pos := 0
+	if offset == 0 {
+		copy(dst, src[:8])
+		pos = 8
+	}
+	var n int
+	var err error
+	lz.lits, n, err = decodeOodleBytes(src[pos:], dstCount)
+	if err != nil {
+		return nil, err
+	}
+	pos += n
+	lz.cmds, n, err = decodeOodleBytes(src[pos:], dstCount)
+	if err != nil {
+		return nil, err
+	}
+	pos += n
+	lz.cmdsSplit = len(lz.cmds)
+	if dstCount > 0x10000 {
+		if len(src)-pos < 2 {
+			return nil, errOodleCorrupt
+		}
+		lz.cmdsSplit = int(binary.LittleEndian.Uint16(src[pos:]))
+		pos += 2
+		if lz.cmdsSplit > len(lz.cmds) {
+			return nil, errOodleCorrupt
+		}
+	}
+
+	if len(src)-pos < 2 {
+		return nil, errOodleCorrupt
+	}
+	off16Count := int(binary.LittleEndian.Uint16(src[pos:]))
+	pos += 2
+	if off16Count == 0xFFFF {
+		// the high and low bytes are entropy coded separately
+		hi, n, err := decodeOodleBytes(src[pos:], dstCount>>1)
+		if err != nil {
+			return nil, err
+		}
+		pos += n
+		lo, n, err := decodeOodleBytes(src[pos:], dstCount>>1)
+		if err != nil {
+			return nil, err
+		}
+		pos += n
+		if len(lo) != len(hi) {
+			return nil, errOodleCorrupt
+		}
+		lz.off16 = make([]int, len(lo))
+		for i := range lo {
+			lz.off16[i] = int(lo[i]) + int(hi[i])*256
+		}
+	} else {
+		if len(src)-pos < off16Count*2 {
+			return nil, errOodleCorrupt
+		}
+		lz.off16 = make([]int, off16Count)
+		for i := range lz.off16 {
+			lz.off16[i] = int(binary.LittleEndian.Uint16(src[pos+2*i:]))
+		}
+		pos += off16Count * 2
+	}
+
+	if len(src)-pos < 3 {
+		return nil, errOodleCorrupt
+	}
+	sizes := int(src[pos]) | int(src[pos+1])<<8 | int(src[pos+2])<<16
+	pos += 3
+	if sizes != 0 {
+		size1 := sizes >> 12
+		size2 := sizes & 0xFFF
+		if size1 == 4095 {
+			if len(src)-pos < 2 {
+				return nil, errOodleCorrupt
+			}
+			size1 = int(binary.LittleEndian.Uint16(src[pos:]))
+			pos += 2
+		}
+		if size2 == 4095 {
+			if len(src)-pos < 2 {
+				return nil, errOodleCorrupt
+			}
+			size2 = int(binary.LittleEndian.Uint16(src[pos:]))
+			pos += 2
+		}
+		for i, size := range []int{size1, size2} {
+			lz.off32[i], n, err = mermaidDecodeFarOffsets(src[pos:], size, offset+i*0x10000)
+			if err != nil {
+				return nil, err
+			}
+			pos += n
+		}
+	}
+	lz.lengths = src[pos:]
+	return lz, nil
+}
+
+// reads a length from the length stream, large lengths take 3 bytes
+func (lz *mermaidLzTable) readLength() (int, error) {
+	if lz.lengthsPos >= len(lz.lengths) {
+		return 0, errOodleCorrupt
+	}
+	length := int(lz.lengths[lz.lengthsPos])
+	if length > 251 {
+		if len(lz.lengths)-lz.lengthsPos < 3 {
+			return 0, errOodleCorrupt
+		}
+		length += 4 * int(binary.LittleEndian.Uint16(lz.lengths[lz.lengthsPos+1:]))
+		lz.lengthsPos += 2
+	}
+	lz.lengthsPos++
+	return length, nil
+}
+
+// decodes one half of 64KB, from begin to end
+func (lz *mermaidLzTable) decodeHalf(mode int, dst []byte, begin int, end int, start int, cmds []byte, off32 []int, recent *int) error {
+	d := start
+	copyLits := func(n int) error {
+		if n > len(lz.lits) || end-d < n {
+			return errOodleCorrupt
+		}
+		copyLiterals(dst, d, lz.lits[:n], mode == 0, *recent)
+		lz.lits = lz.lits[n:]
+		d += n
+		return nil
+	}
+	copyFrom := func(src int, n int) error {
+		if src < 0 || src >= d || end-d < n {
+			return errOodleCorrupt
+		}
+		copyMatch(dst, d, src-d, n)
+		d += n
+		return nil
+	}
+	nextFar := func() (int, error) {
+		if len(off32) == 0 {
+			return 0, errOodleCorrupt
+		}
+		src := begin - off32[0]
+		off32 = off32[1:]
+		return src, nil
+	}
+	for _, cmd := range cmds {
+		var err error
+		switch {
+		case cmd >= 24:
+			// a few literals and a short match, at a new near distance or at the last distance
+			if err = copyLits(int(cmd & 7)); err != nil {
+				return err
+			}
+			if cmd&0x80 == 0 {
+				if len(lz.off16) == 0 {
+					return errOodleCorrupt
+				}
+				*recent = -lz.off16[0]
+				lz.off16 = lz.off16[1:]
+			}
+			err = copyFrom(d+*recent, int(cmd>>3)&0xF)
+		case cmd > 2:
+			// a short far match
+			var src int
+			if src, err = nextFar(); err == nil {
+				*recent = src - d
+				err = copyFrom(src, int(cmd)+5)
+			}
+		case cmd == 0:
+			// a long run of literals
+			var length int
+			if length, err = lz.readLength(); err == nil {
+				err = copyLits(length + 64)
+			}
+		case cmd == 1:
+			// a long near match
+			var length int
+			if length, err = lz.readLength(); err == nil {
+				if len(lz.off16) == 0 {
+					return errOodleCorrupt
+				}
+				*recent = -lz.off16[0]
+				lz.off16 = lz.off16[1:]
+				err = copyFrom(d+*recent, length+91)
+			}
+		default:
+			// a long far match
+			var length, src int
+			if length, err = lz.readLength(); err == nil {
+				if src, err = nextFar(); err == nil {
+					*recent = src - d
+					err = copyFrom(src, length+29)
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if d > end {
+		return errOodleCorrupt
+	}
+	return copyLits(end - d)
+}
+
+func mermaidDecodeLZ(mode int, src []byte, dst []byte, offset int, dstCount int) error {
+	lz, err := mermaidReadLzTable(mode, src, dst, offset, dstCount)
+	if err != nil {
+		return err
+	}
+	recent := -8
+	begin := offset
+	for i := 0; i < 2 && begin < offset+dstCount; i++ {
+		end := minInt(begin+0x10000, offset+dstCount)
+		start := begin
+		if offset == 0 && i == 0 {
+			start = 8
+		}
+		cmds := lz.cmds[:lz.cmdsSplit]
+		if i == 1 {
+			cmds = lz.cmds[lz.cmdsSplit:]
+		}
+		if err := lz.decodeHalf(mode, dst, begin, end, start, cmds, lz.off32[i], &recent); err != nil {
+			return err
+		}
+		begin = end
+	}
+	if lz.lengthsPos != len(lz.lengths) {
+		return errOodleCorrupt
+	}
+	return nil
+}
+
+/* Leviathan */
+
+const (
+	leviathanModeSub     = 0 // literals are added to the byte at the last distance
+	leviathanModeRaw     = 1
+	leviathanModeLamSub  = 2 // like sub, the first literal after a match comes from its own stream
+	leviathanModeSubAnd3 = 3 // like sub, with a stream for each position modulo 4
+	leviathanModeO1      = 4 // a stream for each value of the high 4 bits of the previous byte
+	leviathanModeSubAndF = 5 // like sub, with a stream for each position modulo 16
+)
+
+type leviathanLiterals struct {
+	mode       int
+	streams    [][]byte
+	chunkStart int
+}
+
+// takes the next literal from a stream
+func (l *leviathanLiterals) next(stream int) (byte, error) {
+	if len(l.streams[stream]) == 0 {
+		return 0, errOodleCorrupt
+	}
+	b := l.streams[stream][0]
+	l.streams[stream] = l.streams[stream][1:]
+	return b, nil
+}
+
+func (l *leviathanLiterals) copy(dst []byte, d int, n int, distance int) error {
+	for i := 0; i < n; i++ {
+		stream := 0
+		switch l.mode {
+		case leviathanModeLamSub:
+			if i == 0 {
+				stream = 1
+			}
+		case leviathanModeSubAnd3:
+			stream = (d + i - l.chunkStart) & 3
+		case leviathanModeSubAndF:
+			stream = (d + i - l.chunkStart) & 15
+		case leviathanModeO1:
+			stream = int(dst[d+i-1] >> 4)
+		}
+		b, err := l.next(stream)
+		if err != nil {
+			return err
+		}
+		if l.mode != leviathanModeRaw && l.mode != leviathanModeO1 {
+			b += dst[d+i+distance]
+		}
+		dst[d+i] = b
+	}
+	return nil
+}
+
+func leviathanDecodeLZ(mode int, src []byte, dst []byte, offset int, dstCount int) error {
+	if mode > 5 || len(src) < 13 {
+		return errOodleCorrupt
+	}
+	pos := 0
+	if offset == 0 {
+		copy(dst, src[:8])
+		pos = 8
+	}
+	packedOffs, packedOffsExtra, scale, n, err := readPackedOffsets(src, pos, dstCount/3)
+	if err != nil {
+		return err
+	}
+	pos += n
+	packedLens, n, err := decodeOodleBytes(src[pos:], dstCount/5)
+	if err != nil {
+		return err
+	}
+	pos += n
+
+	lits := &leviathanLiterals{mode: mode, chunkStart: offset}
+	if mode <= leviathanModeRaw {
+		out, n, err := decodeOodleBytes(src[pos:], dstCount)
+		if err != nil {
+			return err
+		}
+		lits.streams = [][]byte{out}
+		pos += n
+	} else {
+		arrayCount := 16
+		if mode == leviathanModeLamSub {
+			arrayCount = 2
+		} else if mode == leviathanModeSubAnd3 {
+			arrayCount = 4
+		}
+		lits.streams, n, err = decodeMultiArray(src[pos:], dstCount, arrayCount)
+		if err != nil {
+			return err
+		}
+		pos += n
+	}
+
+	if pos >= len(src) {
+		return errOodleCorrupt
+	}
+	// the commands are either one stream, or a stream for each position modulo 8
+	var cmds [][]byte
+	cmdCount := 0
+	if src[pos]&0x80 == 0 {
+		out, n, err := decodeOodleBytes(src[pos:], dstCount)
+		if err != nil {
+			return err
+		}
+		cmds = [][]byte{out}
+		cmdCount = len(out)
+		pos += n
+	} else {
+		if src[pos] != 0x83 {
+			return errOodleCorrupt
+		}
+		cmds, n, err = decodeMultiArray(src[pos+1:], dstCount, 8)
+		if err != nil {
+			return err
+		}
+		for _, c := range cmds {
+			cmdCount += len(c)
+		}
+		pos += 1 + n
+	}
+	offs, lens, err := unpackOffsets(src[pos:], packedOffs, packedOffsExtra, scale, packedLens)
+	if err != nil {
+		return err
+	}
+
+	// every command has 3 bits for the length of the match, 2 bits for the number of literals and 3 bits
+	// that choose one of the 7 most recent distances, or a new distance.
+	// Long literal runs take their length from the start of the length stream, long matches from the end.
+	d := offset
+	if offset == 0 {
+		d = 8
+	}
+	end := offset + dstCount
+	var recent [8]int
+	for i := range recent {
+		recent[i] = -8
+	}
+	distance := -8
+	oi := 0
+	for ; cmdCount > 0; cmdCount-- {
+		stream := 0
+		if len(cmds) == 8 {
+			stream = (d - offset) & 7
+		}
+		if len(cmds[stream]) == 0 {
+			return errOodleCorrupt
+		}
+		cmd := cmds[stream][0]
+		cmds[stream] = cmds[stream][1:]
+
+		offsIndex := int(cmd >> 5)
+		matchlen := int(cmd&7) + 2
+		litlen := int(cmd>>3) & 3
+		if litlen == 3 {
+			if len(lens) == 0 {
+				return errOodleCorrupt
+			}
+			litlen = lens[0]
+			lens = lens[1:]
+		}
+		if end-d < litlen {
+			return errOodleCorrupt
+		}
+		if err := lits.copy(dst, d, litlen, distance); err != nil {
+			return err
+		}
+		d += litlen
+
+		if offsIndex == 7 {
+			if oi >= len(offs) {
+				return errOodleCorrupt
+			}
+			recent[7] = offs[oi]
+			oi++
+		}
+		distance = recent[offsIndex]
+		copy(recent[1:offsIndex+1], recent[:offsIndex])
+		recent[0] = distance
+		if distance >= 0 || d+distance < 0 {
+			return errOodleCorrupt
+		}
+		if matchlen == 9 {
+			if len(lens) == 0 {
+				return errOodleCorrupt
+			}
+			matchlen = lens[len(lens)-1] + 6
+			lens = lens[:len(lens)-1]
+		}
+		if end-d < matchlen {
+			return errOodleCorrupt
+		}
+		copyMatch(dst, d, distance, matchlen)
+		d += matchlen
+	}
+	if oi != len(offs) || len(lens) != 0 || d > end {
+		return errOodleCorrupt
+	}
+	return lits.copy(dst, d, end-d, distance)
+}
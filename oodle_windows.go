@@ -0,0 +1,85 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+
+	"github.com/new-world-tools/go-oodle"
+)
+
+// the default oo2core_9_win64.dll, found in the working directory or downloaded by go-oodle
+type defaultOodleLibrary struct{}
+
+func (defaultOodleLibrary) decompress(in []byte, outputSize int) ([]byte, error) {
+	return oodle.Decompress(in, int64(outputSize))
+}
+func (defaultOodleLibrary) compress(in []byte, compressor int, level int) ([]byte, error) {
+	return oodle.Compress(in, compressor, level)
+}
+
+func loadDefaultOodleLibrary() (oodleLibrary, error) {
+	if !oodle.IsDllExist() {
+		return nil, errors.New("oo2core_9_win64.dll was not found")
+	}
+	return defaultOodleLibrary{}, nil
+}
+
+func downloadOodleLibrary() error {
+	return oodle.Download()
+}
+
+// an Oodle DLL at a path given by the user
+type dllOodleLibrary struct {
+	decompressProc *syscall.Proc
+	compressProc   *syscall.Proc
+}
+
+func loadOodleLibrary(path string) (oodleLibrary, error) {
+	dll, err := syscall.LoadDLL(path)
+	if err != nil {
+		return nil, err
+	}
+	lib := &dllOodleLibrary{}
+	if lib.decompressProc, err = dll.FindProc("OodleLZ_Decompress"); err != nil {
+		return nil, err
+	}
+	if lib.compressProc, err = dll.FindProc("OodleLZ_Compress"); err != nil {
+		return nil, err
+	}
+	return lib, nil
+}
+
+func (lib *dllOodleLibrary) decompress(in []byte, outputSize int) ([]byte, error) {
+	if len(in) == 0 || outputSize == 0 {
+		return nil, errors.New("oodle: nothing to decompress")
+	}
+	output := make([]byte, outputSize)
+	r1, _, _ := lib.decompressProc.Call(
+		uintptr(unsafe.Pointer(&in[0])), uintptr(len(in)),
+		uintptr(unsafe.Pointer(&output[0])), uintptr(outputSize),
+		1, 0, 0, 0, 0, 0, 0, 0, 0, 3, // fuzzSafe, as in oodle_dlopen.go
+	)
+	if int(r1) != outputSize {
+		return nil, errors.New("oodle did not decompress correctly")
+	}
+	return output, nil
+}
+
+func (lib *dllOodleLibrary) compress(in []byte, compressor int, level int) ([]byte, error) {
+	if len(in) == 0 {
+		return []byte{}, nil
+	}
+	output := make([]byte, oodleCompressBound(len(in)))
+	r1, _, _ := lib.compressProc.Call(
+		uintptr(compressor), uintptr(unsafe.Pointer(&in[0])), uintptr(len(in)),
+		uintptr(unsafe.Pointer(&output[0])), uintptr(level),
+		0, 0, 0, 0, 0,
+	)
+	if int(r1) <= 0 {
+		return nil, errors.New("oodle compression failed")
+	}
+	return output[:r1], nil
+}
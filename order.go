@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The order of the chunks in a container affects load times, as the engine reads the files that are
+// loaded together from the same part of the .ucas file. The pack order is either one of the sort strategies
+// below, or the path of an order file like the GameOpenOrder.log files that the engine writes:
+// one path per line, optionally quoted and followed by its order number.
+// Sorting is stable, so files that are equal keep the order of the manifest.
+
+const (
+	PackOrderManifest     = "manifest"     // the order of the files in the manifest; the default
+	PackOrderContainer    = "container"    // the order of the original container, as recorded in the manifest
+	PackOrderPath         = "path"         // alphabetically by path
+	PackOrderChunkType    = "type"         // export bundles first, then the bulk data
+	PackOrderDependencies = "dependencies" // the dependencies of a package before the package itself
+)
+
+// sortPackOrder returns the files of the manifest in the given pack order.
+// The dependencies "file" is always placed last, unless the manifest or container order is used.
+func sortPackOrder(m *Manifest, order string) ([]ManifestFile, error) {
+	files := append([]ManifestFile{}, m.Files...)
+	switch strings.ToLower(order) {
+	case "", PackOrderManifest:
+		return files, nil
+	case PackOrderContainer:
+		// manifests of version 1 have no order, but they list the files in the order of the container
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].Order < files[j].Order
+		})
+		return files, nil
+	}
+	var depFile []ManifestFile
+	for i := 0; i < len(files); i++ {
+		if files[i].Filepath == DepFileName {
+			depFile = append(depFile, files[i])
+			files = append(files[:i], files[i+1:]...)
+			i--
+		}
+	}
+	var err error
+	switch strings.ToLower(order) {
+	case PackOrderPath:
+		sort.SliceStable(files, func(i, j int) bool {
+			return strings.ToLower(files[i].Filepath) < strings.ToLower(files[j].Filepath)
+		})
+	case PackOrderChunkType:
+		sort.SliceStable(files, func(i, j int) bool {
+			return FromHexString(files[i].ChunkID).Type < FromHexString(files[j].ChunkID).Type
+		})
+	case PackOrderDependencies:
+		files = dependencyOrder(files, &m.Deps)
+	default:
+		files, err = orderFileOrder(files, order)
+	}
+	return append(files, depFile...), err
+}
+
+// dependencyOrder places all chunks of a package after the packages it depends on.
+// The packages are visited in the order of the manifest; cyclic dependencies are ignored.
+func dependencyOrder(files []ManifestFile, deps *Dependencies) []ManifestFile {
+	byPackage := make(map[uint64][]ManifestFile)
+	var packages []uint64
+	for _, f := range files {
+		id := FromHexString(f.ChunkID).ID
+		if _, ok := byPackage[id]; !ok {
+			packages = append(packages, id)
+		}
+		byPackage[id] = append(byPackage[id], f)
+	}
+	visited := make(map[uint64]bool)
+	ordered := make([]ManifestFile, 0, len(files))
+	var visit func(id uint64)
+	visit = func(id uint64) {
+		if visited[id] {
+			return
+		}
+		visited[id] = true
+		for _, dep := range deps.ChunkIDToDependencies[id].Dependencies {
+			if _, ok := byPackage[dep]; ok {
+				visit(dep)
+			}
+		}
+		ordered = append(ordered, byPackage[id]...)
+	}
+	for _, id := range packages {
+		visit(id)
+	}
+	return ordered
+}
+
+// orderFileOrder sorts the files by their order in an order file; files that are not listed are placed last.
+// The paths in order files are usually relative to the engine ("../../../Game/Content/Maps/Map.umap"),
+// so a file matches when the path in the order file ends with its path. If the file itself is not listed,
+// the order of a listed file with the same name but another extension is used, so the .ubulk file follows its .uasset.
+func orderFileOrder(files []ManifestFile, orderFile string) ([]ManifestFile, error) {
+	f, err := os.Open(orderFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errors.New("unknown pack order " + orderFile + "; use manifest, container, path, type, dependencies or the path of an order file")
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// the order number of every path in the order file, by each of its suffixes that starts with a slash
+	bySuffix := make(map[string]int64)
+	lineCount := int64(0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fpath, number := line, lineCount
+		lineCount++
+		if strings.HasPrefix(line, "\"") {
+			if end := strings.Index(line[1:], "\""); end >= 0 {
+				fpath = line[1 : end+1]
+				if n, err := strconv.ParseInt(strings.TrimSpace(line[end+2:]), 10, 64); err == nil {
+					number = n
+				}
+			}
+		} else if i := strings.LastIndexAny(line, " \t"); i >= 0 {
+			if n, err := strconv.ParseInt(line[i+1:], 10, 64); err == nil {
+				fpath, number = strings.TrimSpace(line[:i]), n
+			}
+		}
+		fpath = strings.ToLower(strings.ReplaceAll(fpath, "\\", "/"))
+		for i := strings.Index(fpath, "/"); i >= 0; i = strings.Index(fpath, "/") {
+			fpath = fpath[i:]
+			if _, ok := bySuffix[fpath]; !ok {
+				bySuffix[fpath] = number
+			}
+			fpath = fpath[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	const notListed = int64(^uint64(0) >> 1)
+	orderOf := make([]int64, len(files))
+	for i, file := range files {
+		fpath := "/" + strings.TrimPrefix(strings.ToLower(file.Filepath), "/")
+		n, ok := bySuffix[fpath]
+		if !ok {
+			stem := strings.TrimSuffix(fpath, path.Ext(fpath))
+			for _, ext := range []string{".uasset", ".umap"} {
+				if n, ok = bySuffix[stem+ext]; ok {
+					break
+				}
+			}
+		}
+		if !ok {
+			n = notListed
+		}
+		orderOf[i] = n
+	}
+	indices := make([]int, len(files))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.SliceStable(indices, func(a, b int) bool {
+		return orderOf[indices[a]] < orderOf[indices[b]]
+	})
+	ordered := make([]ManifestFile, len(files))
+	for i, idx := range indices {
+		ordered[i] = files[idx]
+	}
+	return ordered, nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"crypto/rsa"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -11,11 +12,40 @@ import (
 )
 
 const (
-	CompSize              = 0x10000 // default size of a compression block. Haven't seen any others
+	CompSize              = 0x10000 // default size of a compression block; games may use a different CompressionBlockSize
 	PackUtocVersion       = 3       //3 is PartitionSize, 2 is DirectoryIndex according to https://github.com/FabianFG/CUE4Parse/blob/master/CUE4Parse/UE4/IO/Objects/FIoStoreTocHeader.cs
 	CompressionNameLength = 32
+
+	// PackCompressionOriginal compresses every file with the compression method that the manifest records for it,
+	// which is the method of the container that the manifest was created from
+	PackCompressionOriginal = "original"
 )
 
+// PackOptions are the settings for packing the files of a manifest; the zero value packs
+// uncompressed files with 64 KiB blocks, in the order of the manifest.
+type PackOptions struct {
+	Compression   string // compression policy, see ParseCompressionPolicy, or PackCompressionOriginal
+	BlockSize     uint32 // size of the compression blocks; 0 for the size of the manifest, or the default of 64 KiB
+	Order         string // pack order or the path of an order file, see sortPackOrder
+	FileAlignment uint32 // alignment of the first compressed block of every file in the .ucas file; 0 for 16 bytes
+	Deduplicate   bool   // files with the same content share their compressed blocks
+	CacheDir      string // directory of the pack cache, so unchanged files aren't compressed again; empty for no cache
+	MountPoint    string // mount point of the container, see containerMountPoint; empty to keep the one of the manifest
+	SigningKey    string // path of a PEM file with the RSA private key that signs the container; empty for no signature
+	Discover      bool   // files that are not at their path in the directory are searched, see locatePackFiles
+	Subset        bool   // files of the manifest that are not found are left out, instead of giving an error
+	AESKey        []byte
+	AESKeyGuid    FGuid // the GUID of the AES key, which is written to the header of an encrypted container
+}
+
+// the block size is written in 24 bits of a compression block entry, so it must be smaller than 16 MiB
+func checkBlockSize(blockSize uint32) error {
+	if blockSize == 0 || blockSize > MaxBlockSize {
+		return fmt.Errorf("the compression block size %#x must be between 1 byte and 16 MiB", blockSize)
+	}
+	return nil
+}
+
 func listFilesInDir(dir string, pathToChunkID *map[string]FIoChunkID) (*[]GameFileMetaData, error) {
 	var files []GameFileMetaData
 	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
@@ -58,16 +88,18 @@ func listFilesInDir(dir string, pathToChunkID *map[string]FIoChunkID) (*[]GameFi
 //  - compresses all the files as specified
 //  - records all metadata of packing, required for the program.
 //  - writes the compressed files to the .ucas file - not yet encrypted!
-func packFilesToUcas(files *[]GameFileMetaData, m *Manifest, dir string, outFilename string, compression string) error {
+// The compression methods that are used are returned, starting with "None".
+func packFilesToUcas(files *[]GameFileMetaData, m *Manifest, sources packSources, outFilename string, opts PackOptions) ([]string, error) {
+	blockSize := opts.BlockSize
 
 	/* manually add the "dependencies" section here */
-	// only include the dependencies that are present
-	subsetDependencies := make(map[uint64]FileDependency)
+	// only include the dependencies that are present; the manifest itself is not changed
+	var packageIDs []uint64
 	for _, v := range *files {
 		// all values are ChunkIDs
-		subsetDependencies[v.chunkID.ID] = (*m).Deps.ChunkIDToDependencies[v.chunkID.ID]
+		packageIDs = append(packageIDs, v.chunkID.ID)
 	}
-	(*m).Deps.ChunkIDToDependencies = subsetDependencies
+	deps := m.Deps.subset(packageIDs)
 
 	// find uint64 of depfile
 	depHexString := ""
@@ -77,81 +109,176 @@ func packFilesToUcas(files *[]GameFileMetaData, m *Manifest, dir string, outFile
 		}
 	}
 
-	compMethodNumber := uint8(0)
-	if strings.ToLower(compression) != "none" {
-		compMethodNumber = 1
+	// with the original compression, the codecs are chosen per file below
+	original := strings.EqualFold(opts.Compression, PackCompressionOriginal)
+	policySpec := opts.Compression
+	if original {
+		policySpec = "None"
 	}
-	compFun := getCompressionFunction(compression)
-	if compFun == nil {
-		return errors.New("could not find compression method. Please use none, oodle or zlib")
+	policy, err := ParseCompressionPolicy(policySpec)
+	if err != nil {
+		return nil, err
+	}
+	originalCodecs := make(map[string]Codec)
+	manifestFiles := make(map[FIoChunkID]*ManifestFile)
+	for i := range m.Files {
+		manifestFiles[FromHexString(m.Files[i].ChunkID)] = &m.Files[i]
+	}
+	var cache *packCache
+	if opts.CacheDir != "" {
+		cache, err = openPackCache(opts.CacheDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	compressionMethods := []string{"None"}
+	methodNumber := func(method string) uint8 {
+		for i, m := range compressionMethods {
+			if m == method {
+				return uint8(i)
+			}
+		}
+		compressionMethods = append(compressionMethods, method)
+		return uint8(len(compressionMethods) - 1)
 	}
 
 	// create the new file in a new directory
 	directory := filepath.Dir(outFilename)
 	os.MkdirAll(directory, 0700)
-	f, err := os.OpenFile(outFilename+".ucas", os.O_CREATE|os.O_WRONLY, 0644)
+	f, err := os.OpenFile(outFilename+".ucas", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer f.Close() // all file data is written in this function
 
+	// with deduplication, a file with the same content as an earlier file points at the blocks of that file
+	type contentKey struct {
+		hash   FIoChunkHash
+		length uint64
+	}
+	written := make(map[contentKey]int)
+	nextOffset := uint64(0)
+
 	for i := 0; i < len(*files); i++ {
-		b, err := os.ReadFile(dir + (*files)[i].filepath)
+		b, err := os.ReadFile(sources[(*files)[i].filepath])
 
 		// sorry, this is a little cursed
 		if err != nil && (*files)[i].filepath != DepFileName {
-			return err
+			return nil, err
 		}
 		// if the file doesnt exist, but the filepath indicates it's the dependency file...
 		if (*files)[i].filepath == DepFileName {
 			// attempt to deparse, fix filepath, set chunkid
-			b = *(*m).Deps.Deparse()
+			b = *deps.Deparse()
 			(*files)[i].filepath = ""
 			(*files)[i].chunkID = FromHexString(depHexString)
 		}
-		(*files)[i].offlen.SetLength(uint64(len(b)))
-		if i == 0 {
-			(*files)[i].offlen.SetOffset(0)
-		} else {
-			offset := (*files)[i-1].offlen.GetOffset() + (*files)[i-1].offlen.GetLength()
-			offset = ((offset + CompSize - 1) / CompSize) * CompSize // align to 0x1000
-			(*files)[i].offlen.SetOffset(offset)
+		if err := (*files)[i].offlen.SetLength(uint64(len(b))); err != nil {
+			return nil, fmt.Errorf("%s: %w", (*files)[i].filepath, err)
 		}
 		(*files)[i].metadata.ChunkHash = *sha1Hash(&b)
 		(*files)[i].metadata.Flags = 1 // not sure what this should be?
-
-		// now perform compression, write per compressed block to ucas file
-		for len(b) != 0 {
-			var chunk []byte
-			var block FIoStoreTocCompressedBlockEntry
-			chunkLen := len(b)
-			if chunkLen > CompSize {
-				chunkLen = CompSize
+		mf := manifestFiles[(*files)[i].chunkID]
+		if mf != nil && mf.MetaFlags != nil {
+			(*files)[i].metadata.Flags = FIoStoreTocEntryMetaFlags(*mf.MetaFlags)
+		}
+		key := contentKey{(*files)[i].metadata.ChunkHash, uint64(len(b))}
+		if j, ok := written[key]; ok && opts.Deduplicate {
+			(*files)[i].offlen.SetOffset((*files)[j].offlen.GetOffset())
+			fmt.Println("Deduplicated: ", (*files)[i].filepath)
+			continue
+		}
+		if len(b) != 0 && (*files)[i].filepath != "" {
+			written[key] = i
+		}
+		if err := (*files)[i].offlen.SetOffset(nextOffset); err != nil {
+			return nil, fmt.Errorf("%s: %w", (*files)[i].filepath, err)
+		}
+		// the next file starts at the next block
+		nextOffset += ((uint64(len(b)) + uint64(blockSize) - 1) / uint64(blockSize)) * uint64(blockSize)
+		codec, spec := policy.codecFor((*files)[i].filepath, (*files)[i].chunkID)
+		if original && mf != nil && mf.Compression != "" {
+			spec = mf.Compression
+			codec = originalCodecs[spec]
+			if codec == nil {
+				codec, err = NewCodec(spec)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", (*files)[i].filepath, err)
+				}
+				originalCodecs[spec] = codec
 			}
-			chunk = b[:chunkLen]
-			cChunkPtr, err := compFun(&chunk)
+		}
+
+		// now perform compression, unless the compressed blocks are in the cache
+		var packedBlocks []packedBlock
+		cached := false
+		cacheKey := ""
+		if cache != nil {
+			cacheKey = packCacheKey((*files)[i].metadata.ChunkHash, spec, blockSize)
+			packedBlocks, cached = cache.load(cacheKey, uint64(len(b)))
+		}
+		if !cached {
+			packedBlocks, err = compressBlocks(codec, b, blockSize)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			compressedChunk := *cChunkPtr
+			if cache != nil {
+				if err := cache.store(cacheKey, packedBlocks); err != nil {
+					return nil, fmt.Errorf("could not write to the pack cache: %w", err)
+				}
+			}
+		}
 
-			block.CompressionMethod = compMethodNumber
+		// the first block of the file starts at the file alignment
+		currOffset, _ := f.Seek(0, os.SEEK_CUR)
+		f.Write(make([]byte, (int64(opts.FileAlignment)-currOffset%int64(opts.FileAlignment))%int64(opts.FileAlignment)))
+
+		// write per compressed block to ucas file
+		for _, packed := range packedBlocks {
+			var block FIoStoreTocCompressedBlockEntry
+			compressedChunk := packed.data
+			block.CompressionMethod = methodNumber(packed.method)
 			currOffset, _ := f.Seek(0, os.SEEK_CUR)
-			block.SetOffset(uint64(currOffset))
-			block.SetUncompressedSize(uint32(chunkLen))
-			block.SetCompressedSize(uint32(len(compressedChunk)))
-			// align this compessedChunk to 0x10 with random bytes as padding
-			compressedChunk = append(compressedChunk, getRandomBytes((0x10-(len(compressedChunk)%0x10))&(0x10-1))...)
-			b = b[chunkLen:]
+			if err := block.SetOffset(uint64(currOffset)); err != nil {
+				return nil, err
+			}
+			block.SetUncompressedSize(packed.uncompressedSize)
+			if err := block.SetCompressedSize(uint32(len(compressedChunk))); err != nil {
+				return nil, fmt.Errorf("%s: %w", (*files)[i].filepath, err)
+			}
+			// align this compessedChunk to 0x10 with zeroes as padding, so that packing the same files gives the same output
+			compressedChunk = append(compressedChunk, make([]byte, (0x10-(len(compressedChunk)%0x10))&(0x10-1))...)
 
 			(*files)[i].compressionBlocks = append((*files)[i].compressionBlocks, block)
 
 			// write chunk to the new .ucas file
 			f.Write(compressedChunk)
 		}
-		fmt.Println("Packed: ", (*files)[i].filepath)
+		if cached {
+			fmt.Println("Packed from cache: ", (*files)[i].filepath)
+		} else {
+			fmt.Println("Packed: ", (*files)[i].filepath)
+		}
 	}
-	return nil
+	return compressionMethods, nil
+}
+
+// compressBlocks compresses the data of a file one block at a time
+func compressBlocks(codec Codec, b []byte, blockSize uint32) ([]packedBlock, error) {
+	var blocks []packedBlock
+	for len(b) != 0 {
+		chunkLen := len(b)
+		if chunkLen > int(blockSize) {
+			chunkLen = int(blockSize)
+		}
+		compressedChunk, method, err := compressBlock(codec, nil, b[:chunkLen])
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, packedBlock{method: method, uncompressedSize: uint32(chunkLen), data: compressedChunk})
+		b = b[chunkLen:]
+	}
+	return blocks, nil
 }
 
 func (w *DirIndexWrapper) ToBytes() *[]byte {
@@ -161,7 +288,7 @@ func (w *DirIndexWrapper) ToBytes() *[]byte {
 	fileCount := uint32(len(*w.files))
 	strCount := uint32(len(*w.strSlice))
 	// mount point string
-	mountPointStr := stringToFString(MountPoint)
+	mountPointStr := stringToFString(w.mountPoint)
 	buf.Write(mountPointStr)
 
 	// directory index entries
@@ -185,31 +312,28 @@ func (w *DirIndexWrapper) ToBytes() *[]byte {
 	return &output
 }
 
-func deparseDirectoryIndex(files *[]GameFileMetaData) *[]byte {
+func deparseDirectoryIndex(files *[]GameFileMetaData, mountPoint string) *[]byte {
 	var wrapper DirIndexWrapper
+	wrapper.mountPoint = mountPoint
 	var dirIndexEntries []*FIoDirectoryIndexEntry
 	var fileIndexEntries []*FIoFileIndexEntry
 
-	// first, create unique slice of strings
-	strmap := make(map[string]bool)
+	// first, create unique slice of strings, in the order in which they first occur so the output is always the same.
+	// of this, create a map for quick lookup
+	strSlice := []string{}
+	strIdx := make(map[string]int)
 	for _, v := range *files {
 		dirfiles := strings.Split(v.filepath, "/")
 		if dirfiles[0] == "" {
 			dirfiles = dirfiles[1:]
 		}
 		for _, str := range dirfiles {
-			strmap[str] = true
+			if _, ok := strIdx[str]; !ok {
+				strIdx[str] = len(strSlice)
+				strSlice = append(strSlice, str)
+			}
 		}
 	}
-	strSlice := []string{}
-	for k, _ := range strmap {
-		strSlice = append(strSlice, k)
-	}
-	// of this, create a map for quick lookup
-	strIdx := make(map[string]int)
-	for i, v := range strSlice {
-		strIdx[v] = i
-	}
 	root := FIoDirectoryIndexEntry{
 		Name:             NoneEntry,
 		FirstChildEntry:  NoneEntry,
@@ -233,33 +357,60 @@ func deparseDirectoryIndex(files *[]GameFileMetaData) *[]byte {
 	return wrapper.ToBytes()
 }
 
-func constructUtocFile(files *[]GameFileMetaData, compression string, AESKey []byte) (*[]byte, error) {
+// the compressionMethods must start with "None"; the compression blocks refer to the methods by index.
+// The paths of the files are relative to the mount point, which starts with "../../../".
+// When no file has a path, the container is written without a directory index, like global.utoc.
+// The containerID may be 0, in which case the ID of the container header ("dependencies") is used.
+// With a signer, the container is signed; its block hashes must be those of the final .ucas file.
+func constructUtocFile(files *[]GameFileMetaData, compressionMethods []string, blockSize uint32, mountPoint string, version uint8, containerID FIoContainerID, signer *containerSigner, keyGuid FGuid, AESKey []byte) (*[]byte, error) {
 	var udata UTocData
-	newContainerFlags := uint8(IndexedContainerFlag)
+	// the other versions have a different layout
+	if version < VersionDirectoryIndex || version > VersionPartitionSize {
+		version = PackUtocVersion // like the Grounded files
+	}
+	newContainerFlags := uint8(0)
+	for _, v := range *files {
+		if v.filepath != "" {
+			newContainerFlags |= uint8(IndexedContainerFlag)
+		}
+	}
 
-	compressionMethods := []string{"None"}
-	if strings.ToLower(compression) != "none" {
-		compressionMethods = append(compressionMethods, compression)
+	if len(compressionMethods) > 1 {
 		newContainerFlags |= uint8(CompressedContainerFlag)
 	}
 
 	if len(AESKey) != 0 {
 		newContainerFlags |= uint8(EncryptedContainerFlag)
 	}
+	if signer != nil {
+		newContainerFlags |= uint8(SignedContainerFlag)
+	}
 	compressedBlocksCount := uint32(0)
-	var containerIndex int
 	// fmt.Printf("%+v\n", files)
-	for i, v := range *files {
+	for _, v := range *files {
 		compressedBlocksCount += uint32(len(v.compressionBlocks))
 		// fmt.Println("containerIndex:", v.chunkID.Type)
 		// should be the right type for this container/dependencies/whatever-its-called-chunk
-		if v.chunkID.Type == 10 {
-			containerIndex = i
+		if v.chunkID.Type == 10 && containerID == 0 {
+			containerID = FIoContainerID(v.chunkID.ID)
 			// fmt.Println("containerIndex:", containerIndex)
 		}
 	}
 
-	dirIndexBytes := deparseDirectoryIndex(files)
+	dirIndexBytes := &[]byte{}
+	if newContainerFlags&uint8(IndexedContainerFlag) != 0 {
+		dirIndexBytes = deparseDirectoryIndex(files, mountPoint)
+		// an encrypted directory index is padded with zeroes to the AES block size
+		if len(AESKey) != 0 {
+			padded := make([]byte, (len(*dirIndexBytes)+0xf)&^0xf)
+			copy(padded, *dirIndexBytes)
+			var err error
+			dirIndexBytes, err = encryptAES(&padded, AESKey)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
 	// the container uint64 must be unique and new from any other ID from within the file.
 	// There is a low probability that there is a collision with any other uint64 that is already in the file.
 	// When this happens, the mod won't work without any apparent reason, so this would be the first place to start investigating.
@@ -270,16 +421,17 @@ func constructUtocFile(files *[]GameFileMetaData, compression string, AESKey []b
 	// setting the required header fields
 	udata.hdr = UTocHeader{
 		Magic:                       magic,
-		Version:                     3, // like the Grounded files
+		Version:                     version,
 		HeaderSize:                  uint32(binary.Size(udata.hdr)),
 		EntryCount:                  uint32(len(*files)),
 		CompressedBlockEntryCount:   compressedBlocksCount,
 		CompressedBlockEntrySize:    12,
 		CompressionMethodNameCount:  uint32(len(compressionMethods) - 1), // "extra" methods, other than "none"
 		CompressionMethodNameLength: CompressionNameLength,
-		CompressionBlockSize:        CompSize,
+		CompressionBlockSize:        blockSize,
 		DirectoryIndexSize:          uint32(len(*dirIndexBytes)), // number of bytes in the dirIndex
-		ContainerID:                 FIoContainerID((*files)[containerIndex].chunkID.ID),
+		ContainerID:                 containerID,
+		EncryptionKeyGuid:           keyGuid,
 		ContainerFlags:              EIoContainerFlags(newContainerFlags),
 		PartitionSize:               0xffffffffffffffff,
 		PartitionCount:              1,
@@ -300,25 +452,27 @@ func constructUtocFile(files *[]GameFileMetaData, compression string, AESKey []b
 	}
 
 	// write compression blocks
-	for _, v := range *files {
-		for _, b := range v.compressionBlocks {
-			binary.Write(buf, binary.LittleEndian, b)
-		}
-	}
+	binary.Write(buf, binary.LittleEndian, compressionBlockTable(*files))
 
 	// write compression methods, but skip "none"
 	for _, compMethod := range compressionMethods {
-		if strings.ToLower(compMethod) == "none" {
+		if normalizeCompressionMethod(compMethod) == "none" {
 			continue
 		}
-		capitalized := strings.Title(compMethod)
+		name := compressionMethodName(compMethod)
 		bname := make([]byte, 32)
-		for i := 0; i < len(capitalized); i++ {
-			bname[i] = capitalized[i]
-		}
+		copy(bname, name)
 		binary.Write(buf, binary.LittleEndian, bname)
 	}
 
+	// write the signatures and block hashes, if the container is signed
+	if signer != nil {
+		header := append([]byte{}, buf.Bytes()[:binary.Size(udata.hdr)]...)
+		if err := signer.writeTo(buf, header); err != nil {
+			return nil, err
+		}
+	}
+
 	// write directory index
 	binary.Write(buf, binary.LittleEndian, dirIndexBytes)
 
@@ -332,23 +486,81 @@ func constructUtocFile(files *[]GameFileMetaData, compression string, AESKey []b
 }
 
 // returns the GameFileMetaData of the dependencies file
-func packToCasToc(dir string, m *Manifest, outFilename string, compression string, aes []byte) (int, error) {
+func packToCasToc(dir string, m *Manifest, outFilename string, opts PackOptions) (int, error) {
+	if opts.BlockSize == 0 && m.Container != nil {
+		opts.BlockSize = m.Container.CompressionBlockSize
+	}
+	if opts.BlockSize == 0 {
+		opts.BlockSize = CompSize
+	}
+	if err := checkBlockSize(opts.BlockSize); err != nil {
+		return 0, err
+	}
+	if opts.FileAlignment == 0 {
+		opts.FileAlignment = 0x10
+	}
+	if opts.FileAlignment%0x10 != 0 {
+		return 0, errors.New("the file alignment must be a multiple of 16 bytes")
+	}
+	aes := opts.AESKey
+	files, err := sortPackOrder(m, opts.Order)
+	if err != nil {
+		return 0, err
+	}
+	var signingKey *rsa.PrivateKey
+	if opts.SigningKey != "" {
+		signingKey, err = readRSAPrivateKey(opts.SigningKey)
+		if err != nil {
+			return 0, err
+		}
+	}
+	// the paths in the manifest are relative to its mount point; until the .utoc file is written,
+	// the paths are relative to the root of the game, which is where the files are found in dir
+	manifestMountPoint := ""
+	if m.MountPoint != "" {
+		manifestMountPoint, err = normalizeMountPoint(m.MountPoint)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	fpaths := make([]string, len(files))
+	for i, v := range files {
+		fpaths[i] = v.Filepath
+		if v.Filepath != DepFileName {
+			fpaths[i] = "/" + relativeMountPoint(manifestMountPoint) + strings.TrimPrefix(v.Filepath, "/")
+		}
+	}
+	sources, missing, err := locatePackFiles(dir, fpaths, opts.Discover)
+	if err != nil {
+		return 0, err
+	}
+	if len(missing) != 0 && !opts.Subset {
+		return 0, missingFilesError(dir, missing)
+	}
+	if len(sources) == 0 {
+		return 0, errors.New("none of the files of the manifest were found in " + dir)
+	}
 
 	var offlen FIoOffsetAndLength
 	var fdata []GameFileMetaData
 	var newEntry GameFileMetaData
-	for _, v := range (*m).Files {
-		var p string = filepath.Join(dir, v.Filepath)
-		if info, err := os.Stat(p); err == nil {
+	for i, v := range files {
+		fpath := fpaths[i]
+		if info, err := os.Stat(sources[fpath]); err == nil {
 			// fmt.Println("exists", v.Filepath)
 			offlen.SetLength(uint64(info.Size()))
-		} else if errors.Is(err, os.ErrNotExist) && v.Filepath == DepFileName {
+		} else if fpath == DepFileName {
 			//dependencies file doesnt exist, but still needs to be parsed so add it here anyways!
 			// fmt.Println("exin't", v.Filepath)
 			offlen.SetLength(0) //will be fixed in a later function
+		} else {
+			// only a subset is packed
+			fmt.Println("Skipped: ", fpath)
+			continue
 		}
 		newEntry = GameFileMetaData{
-			filepath: v.Filepath,
+			filepath: fpath,
 			chunkID:  FromHexString(v.ChunkID),
 			offlen:   offlen,
 		}
@@ -365,29 +577,38 @@ func packToCasToc(dir string, m *Manifest, outFilename string, compression strin
 
 	// read each file and place them in a newly created .ucas file with the desired compression method
 	// get the required data such as compression sizes and hashes;
-	err := packFilesToUcas(&fdata, m, dir, outFilename, compression)
+	compressionMethods, err := packFilesToUcas(&fdata, m, sources, outFilename, opts)
 	if err != nil {
 		return 0, err
 	}
 
-	// .ucas file has been written now; encrypt with aes if desired (why would you?)
+	// .ucas file has been written now; encrypt the compression blocks with aes if desired
 	if len(aes) != 0 {
-		b, err := os.ReadFile(outFilename + ".ucas")
-		if err != nil {
-			return 0, err
-		}
-		encrypted, err := encryptAES(&b, aes)
-		if err != nil {
-			return 0, err
-		}
-		err = os.WriteFile(outFilename+".ucas", *encrypted, os.ModePerm)
+		err = encryptUcasFile(outFilename+".ucas", fdata, aes)
 		if err != nil {
 			return 0, err
 		}
 	}
 
 	// .utoc file must be generated, especially the directory index, which is the hardest part.
-	utocBytes, err := constructUtocFile(&fdata, compression, aes)
+	mountPoint, err := containerMountPoint(fdata, manifestMountPoint, opts.MountPoint)
+	if err != nil {
+		return 0, err
+	}
+	signer, err := newContainerSigner(signingKey, outFilename+".ucas", fdata)
+	if err != nil {
+		return 0, err
+	}
+	// the version and container ID of the manifest are kept; the container ID is that of the dependencies otherwise
+	var version uint8
+	if m.Container != nil {
+		version = m.Container.UtocVersion
+	}
+	containerID, err := m.Container.containerID()
+	if err != nil {
+		return 0, err
+	}
+	utocBytes, err := constructUtocFile(&fdata, compressionMethods, opts.BlockSize, mountPoint, version, containerID, signer, opts.AESKeyGuid, aes)
 	if err != nil {
 		return 0, err
 	}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Packs a container with every feature that changes the .ucas layout or the .utoc tables,
+// and reads it back like the DLL does: parse with the keyring, verify, decrypt and unpack.
+func TestPackRoundTrip(t *testing.T) {
+	tmp := t.TempDir()
+	dir := filepath.Join(tmp, "mod")
+
+	// a compressible package spanning several blocks, a copy of it to deduplicate and random bulk data
+	var text bytes.Buffer
+	for i := 0; text.Len() < 0x2800; i++ {
+		text.WriteString(strings.Repeat("export bundle ", i%7+1) + "\n")
+	}
+	noise := make([]byte, 0x1100)
+	rand.Read(noise)
+	contents := map[string][]byte{
+		"/Game/Content/Mod/A.uasset": text.Bytes(),
+		"/Game/Content/Mod/B.uasset": text.Bytes(),
+		"/Game/Content/Mod/A.ubulk":  noise,
+	}
+	for fpath, data := range contents {
+		fname := filepath.Join(dir, filepath.FromSlash(fpath))
+		if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fname, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := Manifest{
+		Files: []ManifestFile{
+			{Filepath: "/Game/Content/Mod/A.uasset", ChunkID: "0000000000000a01000000" + "02"},
+			{Filepath: "/Game/Content/Mod/B.uasset", ChunkID: "0000000000000b01000000" + "02"},
+			{Filepath: "/Game/Content/Mod/A.ubulk", ChunkID: "0000000000000a01000000" + "03"},
+			{Filepath: DepFileName, ChunkID: "00000000000000c1000000" + "0a"},
+		},
+		Deps: Dependencies{
+			ThisPackageID: 0xc1,
+			ChunkIDToDependencies: map[uint64]FileDependency{
+				0xa01: {FileSize: uint64(text.Len()), ExportObjects: 1, MostlyOne: 1},
+				0xb01: {FileSize: uint64(text.Len()), ExportObjects: 1, MostlyOne: 1, SomeIndex: 1, Dependencies: []uint64{0xa01}},
+			},
+		},
+	}
+
+	signingKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(tmp, "key.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(signingKey)})
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	aesKey := make([]byte, 32)
+	rand.Read(aesKey)
+
+	out := filepath.Join(tmp, "packed", "Mod_P")
+	os.MkdirAll(filepath.Dir(out), 0755)
+	opts := PackOptions{
+		Compression:   "zlib",
+		BlockSize:     0x1000,
+		FileAlignment: 0x800,
+		Deduplicate:   true,
+		SigningKey:    keyPath,
+		AESKey:        aesKey,
+	}
+	n, err := packToCasToc(dir, &m, out, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(m.Files) {
+		t.Fatalf("packed %d files, expected %d", n, len(m.Files))
+	}
+
+	keys := NewKeyring()
+	if err := keys.Add(FGuid{}, hex.EncodeToString(aesKey)); err != nil {
+		t.Fatal(err)
+	}
+	d, err := parseUtocFile(out+".utoc", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.hdr.isEncrypted() || !d.hdr.isSigned() || !d.hdr.isIndexed() {
+		t.Fatalf("the container flags are %#x", d.hdr.ContainerFlags)
+	}
+
+	// the block hashes are of the encrypted blocks, the signatures of the header and of those hashes
+	if err := d.verifyBlockHashes(out + ".ucas"); err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := readRSAPublicKey(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.verifySignature(publicKey); err != nil {
+		t.Fatal(err)
+	}
+
+	// the copy shares the blocks of the original, and every file starts at the file alignment
+	blocks := make(map[string][]FIoStoreTocCompressedBlockEntry)
+	for _, f := range d.files {
+		if len(f.compressionBlocks) == 0 {
+			t.Fatalf("%s has no compression blocks", f.name())
+		}
+		if f.compressionBlocks[0].GetOffset()%0x800 != 0 {
+			t.Errorf("%s starts at %#x, which is not aligned to 0x800", f.name(), f.compressionBlocks[0].GetOffset())
+		}
+		blocks[f.filepath] = f.compressionBlocks
+	}
+	// the common directories are moved into the mount point
+	if d.mountPoint != "Game/Content/Mod/" {
+		t.Errorf("the mount point is %s", d.mountPoint)
+	}
+	a, b := blocks["/A.uasset"], blocks["/B.uasset"]
+	if len(a) < 2 || len(a) != len(b) || a[0].GetOffset() != b[0].GetOffset() {
+		t.Errorf("the blocks of the copy are not shared: %v and %v", a, b)
+	}
+
+	decrypted, err := d.decryptUcasToTempFile(out + ".ucas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(decrypted)
+
+	// checked on the stored bytes as well, as packing and reading share the code for the blocks:
+	// every block is encrypted on its own, and its hash is that of the encrypted block padded to 16 bytes
+	stored, err := os.ReadFile(out + ".ucas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := os.ReadFile(decrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.signature.blockHashes) != len(d.compressionBlocks) {
+		t.Fatalf("%d block hashes for %d blocks", len(d.signature.blockHashes), len(d.compressionBlocks))
+	}
+	for i, block := range d.compressionBlocks {
+		start := block.GetOffset()
+		end := start + uint64((block.GetCompressedSize()+0xf)&^0xf)
+		if end > uint64(len(stored)) {
+			t.Fatalf("block %d ends at %#x, after the end of the .ucas file", i, end)
+		}
+		data := stored[start:end]
+		decryptedBlock, err := decryptAES(&data, aesKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(data, plain[start:end]) || !bytes.Equal(*decryptedBlock, plain[start:end]) {
+			t.Errorf("block %d at %#x is not encrypted on its own", i, start)
+		}
+		if sha1.Sum(data) != d.signature.blockHashes[i] {
+			t.Errorf("the hash of block %d at %#x is not that of the stored block", i, start)
+		}
+	}
+	unpacked := filepath.Join(tmp, "unpacked")
+	n, err = d.unpackUcasFiles(decrypted, unpacked, "/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(contents) {
+		t.Errorf("unpacked %d files, expected %d", n, len(contents))
+	}
+	for fpath, data := range contents {
+		got, err := os.ReadFile(filepath.Join(unpacked, filepath.FromSlash(fpath)))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s differs after unpacking", fpath)
+		}
+	}
+
+	// a changed block must not go unnoticed
+	ucas, err := os.ReadFile(out + ".ucas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ucas[a[1].GetOffset()] ^= 0xff
+	if err := os.WriteFile(out+".ucas", ucas, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.verifyBlockHashes(out + ".ucas"); err == nil {
+		t.Error("the block hashes match a modified .ucas file")
+	}
+}
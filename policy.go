@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// A compression policy decides which codec compresses a file. It's written as a list that is separated by
+// semicolons: first the codec specification that is used by default (see NewCodec), followed by rules
+// of the form pattern=codec. The first rule that matches a file is used.
+// A pattern is either a glob on the file name ("*.bnk"), a glob on the complete path when it contains
+// a slash ("/Game/Movies/*"), or a chunk type ("type:BulkData" or "type:3").
+// Example: "oodle:kraken;*.bnk=none;*.bik=none;type:BulkData=zlib"
+// Regardless of the policy, a block is stored uncompressed when compressing it does not make it smaller;
+// the compression method of every block is recorded separately, so this can be mixed freely.
+
+// the names of EIoChunkType by value, https://docs.unrealengine.com/4.26/en-US/API/Runtime/Core/IO/EIoChunkType/
+var chunkTypeNames = []string{
+	"Invalid",
+	"InstallManifest",
+	"ExportBundleData",
+	"BulkData",
+	"OptionalBulkData",
+	"MemoryMappedBulkData",
+	"LoaderGlobalMeta",
+	"LoaderInitialLoadMeta",
+	"LoaderGlobalNames",
+	"LoaderGlobalNameHashes",
+	"ContainerHeader",
+}
+
+// the chunk type of the package data, which has the name map and an entry in the dependencies
+const ExportBundleDataChunkType uint8 = 2
+
+// CompressionRule selects the codec of the files that match the pattern
+type CompressionRule struct {
+	Pattern     string
+	Compression string // codec specification
+	codec       Codec
+}
+
+type CompressionPolicy struct {
+	Default string // codec specification
+	Rules   []CompressionRule
+	codec   Codec
+}
+
+// ParseCompressionPolicy parses a policy; all codecs are created immediately so mistakes are reported here.
+// A single codec specification is a policy without rules.
+func ParseCompressionPolicy(policy string) (*CompressionPolicy, error) {
+	parts := strings.Split(policy, ";")
+	p := &CompressionPolicy{Default: strings.TrimSpace(parts[0])}
+	if p.Default == "" {
+		p.Default = "None"
+	}
+	var err error
+	p.codec, err = NewCodec(p.Default)
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range parts[1:] {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		pattern, spec, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, errors.New("the compression rule " + part + " must be written as pattern=codec")
+		}
+		rule := CompressionRule{Pattern: strings.TrimSpace(pattern), Compression: strings.TrimSpace(spec)}
+		if err := checkRulePattern(rule.Pattern); err != nil {
+			return nil, err
+		}
+		rule.codec, err = NewCodec(rule.Compression)
+		if err != nil {
+			return nil, fmt.Errorf("compression rule %s: %w", rule.Pattern, err)
+		}
+		p.Rules = append(p.Rules, rule)
+	}
+	return p, nil
+}
+
+func checkRulePattern(pattern string) error {
+	if strings.HasPrefix(pattern, "type:") {
+		if _, err := parseChunkType(strings.TrimPrefix(pattern, "type:")); err != nil {
+			return err
+		}
+		return nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return errors.New("invalid pattern in compression rule: " + pattern)
+	}
+	return nil
+}
+
+// the chunk type by name or by number
+func parseChunkType(s string) (uint8, error) {
+	for t, name := range chunkTypeNames {
+		if strings.EqualFold(name, s) {
+			return uint8(t), nil
+		}
+	}
+	t, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, errors.New("unknown chunk type " + s)
+	}
+	return uint8(t), nil
+}
+
+// the name of a chunk type, or its number if the type is unknown
+func chunkTypeName(t uint8) string {
+	if int(t) < len(chunkTypeNames) {
+		return chunkTypeNames[t]
+	}
+	return strconv.Itoa(int(t))
+}
+
+// matches reports whether the rule applies to a file; file names are compared case-insensitively.
+func (r *CompressionRule) matches(fpath string, chunkID FIoChunkID) bool {
+	if strings.HasPrefix(r.Pattern, "type:") {
+		t, _ := parseChunkType(strings.TrimPrefix(r.Pattern, "type:"))
+		return chunkID.Type == t
+	}
+	name := strings.ToLower(fpath)
+	if !strings.Contains(r.Pattern, "/") {
+		name = path.Base(name)
+	}
+	ok, _ := path.Match(strings.ToLower(r.Pattern), name)
+	return ok
+}
+
+// codecFor returns the codec that compresses the given file, and its specification
+func (p *CompressionPolicy) codecFor(fpath string, chunkID FIoChunkID) (Codec, string) {
+	for i := range p.Rules {
+		if p.Rules[i].matches(fpath, chunkID) {
+			return p.Rules[i].codec, p.Rules[i].Compression
+		}
+	}
+	return p.codec, p.Default
+}
+
+// compressBlock compresses one block with the codec; the returned method is "None" when the block
+// is stored as it is, because compressing it didn't make it smaller.
+func compressBlock(codec Codec, dst []byte, block []byte) ([]byte, string, error) {
+	if codec.Name() == "None" {
+		return append(dst, block...), "None", nil
+	}
+	compressed, err := codec.Compress(dst, block)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(compressed)-len(dst) >= len(block) {
+		return append(compressed[:len(dst)], block...), "None", nil
+	}
+	return compressed, codec.Name(), nil
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Searching finds chunks in one or more containers by other properties than their path.
+// A query consists of conditions, separated by semicolons, which must all be true for a chunk:
+//   chunk:<hex>     the chunk ID contains these hexadecimal digits
+//   package:<hex>   the package ID (the ID field of the chunk ID) is this number
+//   hash:<hex>      the SHA1 hash of the uncompressed data starts with these hexadecimal digits
+//   type:<type>     the chunk type, by name or by number, like in compression rules
+//   size:<min-max>  the uncompressed size in bytes; either end may be left out, e.g. "size:1000-" or "size:-5000"
+//   name:<pattern>  the package has a name in its name map that matches the pattern, ignoring the case; * and ? are wildcards
+//   path:<regex>    the path below the root of the game matches the regular expression
+// Example: "type:ExportBundleData;name:BP_Player*;size:-100000"
+// Only ExportBundleData chunks have a name map. The hash in the .utoc file is used if it was stored;
+// otherwise the chunk is decompressed and hashed.
+
+type AssetQuery struct {
+	ChunkID   string  // lowercase hexadecimal digits
+	PackageID *uint64 // nil for any package
+	Hash      string  // lowercase hexadecimal digits
+	ChunkType *uint8  // nil for any type
+	MinSize   uint64
+	MaxSize   *uint64 // nil for no maximum
+	Name      string  // lowercase pattern
+	Path      string  // regular expression
+	pathRegex *regexp.Regexp
+}
+
+type AssetMatch struct {
+	Container string   `json:"container"`
+	Path      string   `json:"path,omitempty"`
+	ChunkID   string   `json:"chunkId"`
+	PackageID string   `json:"packageId"` // 16 hexadecimal digits, the start of the chunk ID
+	ChunkType string   `json:"chunkType"`
+	Size      uint64   `json:"size"`
+	Hash      string   `json:"hash,omitempty"`
+	Names     []string `json:"names,omitempty"` // the names of the name map that matched
+}
+
+// checkHexDigits makes sure that a condition has hexadecimal digits only, and returns them in lowercase
+func checkHexDigits(condition string, s string) (string, error) {
+	s = strings.TrimPrefix(strings.ToLower(s), "0x")
+	if s == "" || strings.Trim(s, "0123456789abcdef") != "" {
+		return "", errors.New("the search condition " + condition + " expects hexadecimal digits")
+	}
+	return s, nil
+}
+
+// ParseAssetQuery parses a query; an empty query matches every chunk
+func ParseAssetQuery(query string) (*AssetQuery, error) {
+	q := &AssetQuery{}
+	for _, part := range strings.Split(query, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, errors.New("the search condition " + part + " must be written as key:value")
+		}
+		value = strings.TrimSpace(value)
+		var err error
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "chunk":
+			q.ChunkID, err = checkHexDigits(part, value)
+		case "package":
+			var digits string
+			digits, err = checkHexDigits(part, value)
+			if err == nil {
+				var id uint64
+				id, err = strconv.ParseUint(digits, 16, 64)
+				q.PackageID = &id
+			}
+		case "hash":
+			q.Hash, err = checkHexDigits(part, value)
+		case "type":
+			var t uint8
+			t, err = parseChunkType(value)
+			q.ChunkType = &t
+		case "size":
+			err = q.parseSizeRange(value)
+		case "name":
+			q.Name = strings.ToLower(value)
+			if _, err = path.Match(q.Name, ""); err != nil {
+				err = errors.New("invalid pattern in search condition " + part)
+			}
+		case "path":
+			q.Path = value
+			q.pathRegex, err = regexp.Compile(value)
+		default:
+			err = errors.New("unknown search condition " + key + "; use chunk, package, hash, type, size, name or path")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+func (q *AssetQuery) parseSizeRange(value string) error {
+	low, high, isRange := strings.Cut(value, "-")
+	if !isRange {
+		high = low
+	}
+	var err error
+	if low != "" {
+		if q.MinSize, err = strconv.ParseUint(strings.TrimSpace(low), 0, 64); err != nil {
+			return errors.New("invalid size in search condition size:" + value)
+		}
+	}
+	if high != "" {
+		maxSize, err := strconv.ParseUint(strings.TrimSpace(high), 0, 64)
+		if err != nil {
+			return errors.New("invalid size in search condition size:" + value)
+		}
+		q.MaxSize = &maxSize
+	}
+	if q.MaxSize != nil && *q.MaxSize < q.MinSize {
+		return errors.New("the size range " + value + " is empty")
+	}
+	return nil
+}
+
+// matchesTOC checks the conditions that only need the .utoc file
+func (q *AssetQuery) matchesTOC(fpath string, f *GameFileMetaData) bool {
+	size := f.offlen.GetLength()
+	switch {
+	case q.ChunkID != "" && !strings.Contains(f.chunkID.ToHexString(), q.ChunkID):
+		return false
+	case q.PackageID != nil && f.chunkID.ID != *q.PackageID:
+		return false
+	case q.ChunkType != nil && f.chunkID.Type != *q.ChunkType:
+		return false
+	case size < q.MinSize || (q.MaxSize != nil && size > *q.MaxSize):
+		return false
+	case q.pathRegex != nil && (fpath == "" || !q.pathRegex.MatchString(fpath)):
+		return false
+	case q.Name != "" && f.chunkID.Type != ExportBundleDataChunkType:
+		return false
+	}
+	return true
+}
+
+// packageNames reads the name map of a package. The package summary has the offset and the size of the name map
+// at byte 24 (see CasTocFormats.md); every name has a two-byte header with the length and whether the name is
+// stored in UTF-16, followed by the characters.
+func packageNames(data []byte) ([]string, error) {
+	if len(data) < 64 {
+		return nil, errors.New("the package is too small for a package summary")
+	}
+	offset := int(int32(binary.LittleEndian.Uint32(data[24:])))
+	size := int(int32(binary.LittleEndian.Uint32(data[28:])))
+	if offset < 64 || size < 0 || offset+size > len(data) {
+		return nil, errors.New("the package summary has an invalid name map")
+	}
+	names := []string{}
+	r := data[offset : offset+size]
+	for len(r) >= 2 {
+		wide := r[0]&0x80 != 0
+		length := int(r[0]&0x7F)<<8 | int(r[1])
+		r = r[2:]
+		if length == 0 {
+			continue // the padding at the end of the name map
+		}
+		if !wide {
+			if length > len(r) {
+				return nil, errors.New("a name in the name map is truncated")
+			}
+			names = append(names, string(r[:length]))
+			r = r[length:]
+			continue
+		}
+		if 2*length > len(r) {
+			return nil, errors.New("a name in the name map is truncated")
+		}
+		chars := make([]uint16, length)
+		for i := range chars {
+			chars[i] = binary.LittleEndian.Uint16(r[2*i:])
+		}
+		names = append(names, string(utf16.Decode(chars)))
+		r = r[2*length:]
+	}
+	return names, nil
+}
+
+// matchingNames returns the names that match the pattern of the query
+func (q *AssetQuery) matchingNames(names []string) []string {
+	matched := []string{}
+	for _, name := range names {
+		if ok, _ := path.Match(q.Name, strings.ToLower(name)); ok {
+			matched = append(matched, name)
+		}
+	}
+	return matched
+}
+
+// searchChunks returns the chunks of the container that match the query; name is the name of the container in the results.
+// The .ucas file is only read when the name map or the hash of a chunk is needed.
+func (d *UTocData) searchChunks(name string, ucasPath string, q *AssetQuery) ([]AssetMatch, error) {
+	matches := []AssetMatch{}
+	var openUcas *os.File
+	defer func() {
+		if openUcas != nil {
+			openUcas.Close()
+		}
+	}()
+	for i := range d.files {
+		f := &d.files[i]
+		if f.filepath == DepFileName {
+			continue
+		}
+		fpath := d.gamePath(f)
+		if !q.matchesTOC(fpath, f) {
+			continue
+		}
+		match := AssetMatch{
+			Container: name,
+			Path:      fpath,
+			ChunkID:   f.chunkID.ToHexString(),
+			PackageID: fmt.Sprintf("%016x", f.chunkID.ID),
+			ChunkType: chunkTypeName(f.chunkID.Type),
+			Size:      f.offlen.GetLength(),
+		}
+		if hashIsSet(&f.metadata.ChunkHash) {
+			match.Hash = hex.EncodeToString(f.metadata.ChunkHash.Hash[:])
+		}
+		if q.Name != "" || (q.Hash != "" && match.Hash == "") {
+			if openUcas == nil {
+				var err error
+				openUcas, err = os.Open(ucasPath)
+				if err != nil {
+					return nil, err
+				}
+			}
+			data, err := d.readFileData(openUcas, f)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.name(), err)
+			}
+			if match.Hash == "" {
+				match.Hash = hex.EncodeToString(sha1Hash(data).Hash[:])
+			}
+			if q.Name != "" {
+				names, err := packageNames(*data)
+				if err != nil {
+					return nil, fmt.Errorf("%s: could not read the name map: %w", f.name(), err)
+				}
+				match.Names = q.matchingNames(names)
+				if len(match.Names) == 0 {
+					continue
+				}
+			}
+		}
+		if !strings.HasPrefix(match.Hash, q.Hash) {
+			continue
+		}
+		matches = append(matches, match)
+	}
+	return matches, nil
+}
+
+// needsUcas reports whether searching the container reads its .ucas file, which is only needed
+// for the name map of a package, or for the hash of a chunk that has no hash in the .utoc file
+func (q *AssetQuery) needsUcas(d *UTocData) bool {
+	if q.Name != "" {
+		return true
+	}
+	if q.Hash == "" {
+		return false
+	}
+	for i := range d.files {
+		f := &d.files[i]
+		if f.filepath != DepFileName && !hashIsSet(&f.metadata.ChunkHash) && q.matchesTOC(d.gamePath(f), f) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchContainers searches all containers in order; the .ucas files that are read (see needsUcas) must not be encrypted
+func searchContainers(sources []MergeSource, q *AssetQuery) ([]AssetMatch, error) {
+	matches := []AssetMatch{}
+	for _, s := range sources {
+		m, err := s.toc.searchChunks(s.name, s.ucasPath, q)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.name, err)
+		}
+		matches = append(matches, m...)
+	}
+	return matches, nil
+}
+
+func searchResultsToJSON(matches []AssetMatch) ([]byte, error) {
+	return json.MarshalIndent(matches, "", "  ")
+}
+
+// searchResultsToText formats the results in a human readable way; one line per chunk
+func searchResultsToText(matches []AssetMatch) string {
+	var sb strings.Builder
+	for _, m := range matches {
+		fmt.Fprintf(&sb, "%s: %s %s (%d bytes)", m.Container, m.ChunkID, m.ChunkType, m.Size)
+		if m.Path != "" {
+			fmt.Fprintf(&sb, " %s", m.Path)
+		}
+		if len(m.Names) != 0 {
+			fmt.Fprintf(&sb, " [%s]", strings.Join(m.Names, ", "))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Signed containers have a signature section after the compression method names:
+// the size of a signature, the signature of the .utoc header, the signature of the block hashes,
+// and the SHA1 hash of every compression block as it's stored in the .ucas file, padded to 16 bytes.
+// A signature is the SHA1 hash that is "encrypted" with the RSA private key (PKCS #1 v1.5 without a digest prefix),
+// which is what the engine does. The block hashes can be verified without a key.
+// Keys are read from PEM files; a public key can also be taken from the private key.
+
+type FSHAHash [20]uint8
+
+type containerSignature struct {
+	header         []byte // the .utoc header as it was signed
+	tocSignature   []byte
+	blockSignature []byte
+	blockHashes    []FSHAHash
+}
+
+// the private key that signs a container that is written, and the hashes of its compression blocks
+type containerSigner struct {
+	key         *rsa.PrivateKey
+	blockHashes []FSHAHash
+}
+
+func parseSignature(r *bytes.Reader, header []byte, blockCount uint32) (*containerSignature, error) {
+	var hashSize int32
+	if err := binary.Read(r, binary.LittleEndian, &hashSize); err != nil {
+		return nil, err
+	}
+	if hashSize < 0 || int64(hashSize)*2+int64(blockCount)*20 > int64(r.Len()) {
+		return nil, errors.New("the signature section of the .utoc file is too large")
+	}
+	sig := &containerSignature{
+		header:         header,
+		tocSignature:   make([]byte, hashSize),
+		blockSignature: make([]byte, hashSize),
+		blockHashes:    make([]FSHAHash, blockCount),
+	}
+	binary.Read(r, binary.LittleEndian, &sig.tocSignature)
+	binary.Read(r, binary.LittleEndian, &sig.blockSignature)
+	binary.Read(r, binary.LittleEndian, &sig.blockHashes)
+	return sig, nil
+}
+
+// the hash of all block hashes, which is signed by the block signature
+func blockHashesHash(blockHashes []FSHAHash) []byte {
+	hasher := sha1.New()
+	binary.Write(hasher, binary.LittleEndian, blockHashes)
+	return hasher.Sum(nil)
+}
+
+func (s *containerSigner) sign(header []byte) (tocSignature []byte, blockSignature []byte, err error) {
+	tocHash := sha1.Sum(header)
+	tocSignature, err = rsa.SignPKCS1v15(rand.Reader, s.key, crypto.Hash(0), tocHash[:])
+	if err != nil {
+		return nil, nil, err
+	}
+	blockSignature, err = rsa.SignPKCS1v15(rand.Reader, s.key, crypto.Hash(0), blockHashesHash(s.blockHashes))
+	return tocSignature, blockSignature, err
+}
+
+// writeTo writes the signature section of a .utoc file with the given header
+func (s *containerSigner) writeTo(buf *bytes.Buffer, header []byte) error {
+	tocSignature, blockSignature, err := s.sign(header)
+	if err != nil {
+		return fmt.Errorf("could not sign the container: %w", err)
+	}
+	binary.Write(buf, binary.LittleEndian, int32(len(tocSignature)))
+	buf.Write(tocSignature)
+	buf.Write(blockSignature)
+	binary.Write(buf, binary.LittleEndian, s.blockHashes)
+	return nil
+}
+
+// newContainerSigner hashes the compression blocks of the written .ucas file, so the container can be signed with the key.
+// Without a key, the container is not signed and nil is returned.
+func newContainerSigner(key *rsa.PrivateKey, ucasPath string, files []GameFileMetaData) (*containerSigner, error) {
+	if key == nil {
+		return nil, nil
+	}
+	hashes, err := hashCompressionBlocks(ucasPath, compressionBlockTable(files))
+	if err != nil {
+		return nil, err
+	}
+	return &containerSigner{key: key, blockHashes: hashes}, nil
+}
+
+// hashCompressionBlocks computes the hash of every compression block, in the order of the block table of the .utoc file.
+func hashCompressionBlocks(ucasPath string, blocks []FIoStoreTocCompressedBlockEntry) ([]FSHAHash, error) {
+	ucas, err := os.Open(ucasPath)
+	if err != nil {
+		return nil, err
+	}
+	defer ucas.Close()
+	hashes := []FSHAHash{}
+	for _, b := range blocks {
+		data := make([]byte, (b.GetCompressedSize()+0xf)&^0xf)
+		if _, err := ucas.ReadAt(data, int64(b.GetOffset())); err != nil {
+			return nil, fmt.Errorf("could not read the block at %#x: %w", b.GetOffset(), err)
+		}
+		hashes = append(hashes, sha1.Sum(data))
+	}
+	return hashes, nil
+}
+
+// verifyBlockHashes compares the hashes of the compression blocks in the .ucas file with the signature section.
+// The .ucas file is read as it is stored, so an encrypted file must not be decrypted first.
+func (u *UTocData) verifyBlockHashes(ucasPath string) error {
+	if u.signature == nil {
+		return errors.New("the container is not signed")
+	}
+	hashes, err := hashCompressionBlocks(ucasPath, u.compressionBlocks)
+	if err != nil {
+		return err
+	}
+	if len(hashes) != len(u.signature.blockHashes) {
+		return errors.New("the number of block hashes does not match the number of compression blocks")
+	}
+	for i := range hashes {
+		if hashes[i] != u.signature.blockHashes[i] {
+			return fmt.Errorf("the hash of compression block %d (%s) does not match", i, u.blockOwner(i))
+		}
+	}
+	return nil
+}
+
+// verifySignature checks the signatures of the header and the block hashes with the public key.
+// The block hashes themselves are checked with verifyBlockHashes.
+func (u *UTocData) verifySignature(key *rsa.PublicKey) error {
+	if u.signature == nil {
+		return errors.New("the container is not signed")
+	}
+	tocHash := sha1.Sum(u.signature.header)
+	if rsa.VerifyPKCS1v15(key, crypto.Hash(0), tocHash[:], u.signature.tocSignature) != nil {
+		return errors.New("the signature of the .utoc file is invalid")
+	}
+	if rsa.VerifyPKCS1v15(key, crypto.Hash(0), blockHashesHash(u.signature.blockHashes), u.signature.blockSignature) != nil {
+		return errors.New("the signature of the block hashes is invalid")
+	}
+	return nil
+}
+
+func readPEMBlock(keyPath string) (*pem.Block, error) {
+	b, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New(keyPath + " is not a PEM file")
+	}
+	return block, nil
+}
+
+// readRSAPrivateKey reads a PKCS #1 or PKCS #8 private key
+func readRSAPrivateKey(keyPath string) (*rsa.PrivateKey, error) {
+	block, err := readPEMBlock(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.New(keyPath + " does not contain an RSA private key")
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New(keyPath + " does not contain an RSA private key")
+	}
+	return rsaKey, nil
+}
+
+// readRSAPublicKey reads a PKCS #1 or PKIX public key, or takes the public part of a private key
+func readRSAPublicKey(keyPath string) (*rsa.PublicKey, error) {
+	block, err := readPEMBlock(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		if rsaKey, ok := key.(*rsa.PublicKey); ok {
+			return rsaKey, nil
+		}
+	}
+	private, err := readRSAPrivateKey(keyPath)
+	if err != nil {
+		return nil, errors.New(keyPath + " does not contain an RSA key")
+	}
+	return &private.PublicKey, nil
+}
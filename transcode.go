@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Transcoding writes a container again with a different compression method, compression block size
+// or alignment of the compressed blocks. For example, Oodle compressed game files can be converted to
+// zlib or uncompressed files for tools that can't handle Oodle.
+// Every block is decompressed and compressed again one at a time, so a complete file never has to fit in memory.
+// Chunk IDs, paths, chunk metas and the container header are kept as they are.
+
+// transcodeContainer writes the container to outFilename{.utoc, .ucas}; the .ucas file must not be encrypted.
+// Pass 0 as blockSize or blockAlignment to keep the block size of the container, or the default alignment.
+func transcodeContainer(src *UTocData, srcUcasPath string, outFilename string, compression string, blockSize uint32, blockAlignment uint32) (int, error) {
+	if err := src.checkRewritable(); err != nil {
+		return 0, err
+	}
+	if blockSize == 0 {
+		blockSize = src.hdr.CompressionBlockSize
+	}
+	if err := checkBlockSize(blockSize); err != nil {
+		return 0, err
+	}
+	if blockAlignment == 0 {
+		blockAlignment = 0x10
+	}
+	if blockAlignment%0x10 != 0 {
+		return 0, errors.New("the block alignment must be a multiple of 16 bytes")
+	}
+	ucas, err := os.Open(srcUcasPath)
+	if err != nil {
+		return 0, err
+	}
+	defer ucas.Close()
+
+	w, err := newContainerWriter(outFilename, blockSize)
+	if err != nil {
+		return 0, err
+	}
+	w.blockAlignment = blockAlignment
+	w.mountPoint = MountPoint + src.mountPoint
+	w.containerID = src.hdr.ContainerID
+	for i, f := range src.files {
+		err = w.beginChunk(f.filepath, f.chunkID, compression)
+		if err != nil {
+			w.abort()
+			return 0, err
+		}
+		for j := range f.compressionBlocks {
+			blockData, err := readCompressionBlocks(ucas, f.compressionBlocks[j:j+1])
+			if err != nil {
+				w.abort()
+				return 0, err
+			}
+			data, err := src.decompressBlock(&f.compressionBlocks[j], blockData[0])
+			if err != nil {
+				w.abort()
+				return 0, fmt.Errorf("could not decompress %s: %w", f.name(), err)
+			}
+			err = w.write(*data)
+			if err != nil {
+				w.abort()
+				return 0, fmt.Errorf("could not compress %s: %w", f.name(), err)
+			}
+		}
+		err = w.endChunk(&src.files[i].metadata)
+		if err != nil {
+			w.abort()
+			return 0, err
+		}
+	}
+	err = w.finish(outFilename, nil)
+	return len(w.files), err
+}
@@ -3,26 +3,160 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 )
 
-func (d *UTocData) unpackFile(fdata *GameFileMetaData, blockData *[][]byte, outDir string) error {
-	os.MkdirAll(outDir, 0700)
+// reads the raw (compressed) data of the given compression blocks from the opened .ucas file
+func readCompressionBlocks(openUcas *os.File, blocks []FIoStoreTocCompressedBlockEntry) ([][]byte, error) {
+	var compressionblockData [][]byte
+	for _, b := range blocks {
+		_, err := openUcas.Seek(int64(b.GetOffset()), 0)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, b.GetCompressedSize())
+		readBytes, err := openUcas.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if uint32(readBytes) != b.GetCompressedSize() {
+			return nil, errors.New("could not read the correct size")
+		}
+		compressionblockData = append(compressionblockData, buf)
+	}
+	return compressionblockData, nil
+}
+
+// decompresses a single compression block with the method that the block refers to
+func (d *UTocData) decompressBlock(block *FIoStoreTocCompressedBlockEntry, data []byte) (*[]byte, error) {
+	method := d.compressionMethods[block.CompressionMethod]
+	codec, err := getCodec(method)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("decompression method %s not known", method))
+	}
+	output := make([]byte, block.GetUncompressedSize())
+	if err = codec.Decompress(output, data); err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+// decompresses the separate blocks of a file and concatenates them
+func (d *UTocData) decompressBlocks(fdata *GameFileMetaData, blockData *[][]byte) (*[]byte, error) {
 	bdata := *blockData
 	outputData := []byte{}
 	for i := 0; i < len(bdata); i++ {
-		method := d.compressionMethods[fdata.compressionBlocks[i].CompressionMethod]
-		decomp := getDecompressionFunction(method)
-		if decomp == nil {
-			return errors.New(fmt.Sprintf("decompression method %s not known", method))
+		newData, err := d.decompressBlock(&fdata.compressionBlocks[i], bdata[i])
+		if err != nil {
+			return nil, err
 		}
-		newData, err := decomp(&(bdata[i]), fdata.compressionBlocks[i].GetUncompressedSize())
+		outputData = append(outputData, (*newData)...)
+	}
+	return &outputData, nil
+}
+
+// reads and decompresses the data of a single file from the opened .ucas file
+func (d *UTocData) readFileData(openUcas *os.File, fdata *GameFileMetaData) (*[]byte, error) {
+	compressionblockData, err := readCompressionBlocks(openUcas, fdata.compressionBlocks)
+	if err != nil {
+		return nil, err
+	}
+	return d.decompressBlocks(fdata, &compressionblockData)
+}
+
+// compressionBlockTable is the compression block table of a container that is written: the blocks of every file, in the order of the .utoc file.
+// A file always starts at a new block, so the blocks of a file are found by its offset.
+// Files with the same content as an earlier file (see PackOptions.Deduplicate) point at the blocks of that file and have no blocks of their own.
+func compressionBlockTable(files []GameFileMetaData) []FIoStoreTocCompressedBlockEntry {
+	blocks := []FIoStoreTocCompressedBlockEntry{}
+	for _, f := range files {
+		blocks = append(blocks, f.compressionBlocks...)
+	}
+	return blocks
+}
+
+// blockOwner returns the name of the first file that uses the compression block with the given index in the block table
+func (u *UTocData) blockOwner(index int) string {
+	blockSize := uint64(u.hdr.CompressionBlockSize)
+	for i := range u.files {
+		start := int(u.files[i].offlen.GetOffset() / blockSize)
+		if index >= start && index < start+len(u.files[i].compressionBlocks) {
+			return u.files[i].name()
+		}
+	}
+	return "no file"
+}
+
+// Like the engine, every compression block of an encrypted container is encrypted on its own, with its size aligned to 16 bytes.
+// The data between the blocks, such as the padding of the file alignment, is not encrypted.
+func cryptCompressionBlocks(ucas *os.File, blocks []FIoStoreTocCompressedBlockEntry, aes []byte, encrypt bool) error {
+	crypt := decryptAES
+	if encrypt {
+		crypt = encryptAES
+	}
+	for _, b := range blocks {
+		data := make([]byte, (b.GetCompressedSize()+0xf)&^0xf)
+		if _, err := ucas.ReadAt(data, int64(b.GetOffset())); err != nil {
+			return fmt.Errorf("could not read the block at %#x: %w", b.GetOffset(), err)
+		}
+		crypted, err := crypt(&data, aes)
 		if err != nil {
 			return err
 		}
-		outputData = append(outputData, (*newData)...)
+		if _, err := ucas.WriteAt(*crypted, int64(b.GetOffset())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encryptUcasFile encrypts the compression blocks of a .ucas file that was just written
+func encryptUcasFile(ucasPath string, files []GameFileMetaData, aes []byte) error {
+	ucas, err := os.OpenFile(ucasPath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	err = cryptCompressionBlocks(ucas, compressionBlockTable(files), aes, true)
+	if closeErr := ucas.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// The .ucas file of an encrypted container is decrypted into a temporary file.
+// The path of that file is returned, the caller is responsible for removing it.
+func (d *UTocData) decryptUcasToTempFile(ucasPath string) (string, error) {
+	tmpFile, err := os.CreateTemp("", "tmp")
+	if err != nil {
+		return "", err
+	}
+	ucas, err := os.Open(ucasPath)
+	if err == nil {
+		_, err = io.Copy(tmpFile, ucas)
+		ucas.Close()
+	}
+	if err == nil {
+		err = cryptCompressionBlocks(tmpFile, d.compressionBlocks, d.aesKey, false)
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return "", err
+	}
+	return tmpFile.Name(), nil
+}
+
+func (d *UTocData) unpackFile(fdata *GameFileMetaData, blockData *[][]byte, outDir string) error {
+	os.MkdirAll(outDir, 0700)
+	outputData, err := d.decompressBlocks(fdata, blockData)
+	if err != nil {
+		return err
 	}
 	// ensure path exists to the file
 	fpath := filepath.Clean(outDir + fdata.filepath)
@@ -30,7 +164,7 @@ func (d *UTocData) unpackFile(fdata *GameFileMetaData, blockData *[][]byte, outD
 
 	os.MkdirAll(directory, 0700)
 	// write the actual data to the new file
-	err := os.WriteFile(fpath, outputData, 0644)
+	err = os.WriteFile(fpath, *outputData, 0644)
 
 	return err
 }
@@ -44,7 +178,8 @@ func (d *UTocData) matchRegex(regex string) *[]GameFileMetaData {
 		}
 		// exclude special "dependencies" file, as it's not meant to be directly unpacked
 		// for unpacking that file, have a look at the function to construct the manifest!
-		if match && v.filepath != DepFileName {
+		// chunks without a path can't be placed in the output directory either.
+		if match && v.filepath != DepFileName && v.filepath != "" {
 			filesToUnpack = append(filesToUnpack, v)
 		}
 	}
@@ -52,7 +187,7 @@ func (d *UTocData) matchRegex(regex string) *[]GameFileMetaData {
 }
 
 func (d *UTocData) unpackUcasFiles(ucasPath string, outDir string, regex string) (filesUnpacked int, err error) {
-	outDir += d.mountPoint // adjust for mountpoint
+	outDir = filepath.Join(outDir, d.mountPoint) // adjust for mountpoint
 	filesUnpacked = 0
 	// read the file
 	openUcas, err := os.Open(ucasPath)
@@ -67,21 +202,9 @@ func (d *UTocData) unpackUcasFiles(ucasPath string, outDir string, regex string)
 	// Since there's one place where the .ucas file is actually read, it can act as a work divider.
 	// that may make it possible to make it run multithreaded in the future!
 	for _, v := range filesToUnpack {
-		var compressionblockData [][]byte
-		for _, b := range v.compressionBlocks {
-			_, err = openUcas.Seek(int64(b.GetOffset()), 0)
-			if err != nil {
-				return filesUnpacked, err
-			}
-			buf := make([]byte, b.GetCompressedSize())
-			readBytes, err := openUcas.Read(buf)
-			if err != nil {
-				return filesUnpacked, err
-			}
-			if uint32(readBytes) != b.GetCompressedSize() {
-				return filesUnpacked, errors.New("could not read the correct size")
-			}
-			compressionblockData = append(compressionblockData, buf)
+		compressionblockData, err := readCompressionBlocks(openUcas, v.compressionBlocks)
+		if err != nil {
+			return filesUnpacked, err
 		}
 		// all separate blocks collected for file unpacking
 		err = d.unpackFile(&v, &compressionblockData, outDir)
@@ -92,3 +215,44 @@ func (d *UTocData) unpackUcasFiles(ucasPath string, outDir string, regex string)
 	}
 	return len(filesToUnpack), nil
 }
+
+// findChunk returns the chunk with the given ID; chunks without a path can only be found this way
+func (d *UTocData) findChunk(chunkID FIoChunkID) (*GameFileMetaData, error) {
+	for i := range d.files {
+		if d.files[i].chunkID == chunkID {
+			return &d.files[i], nil
+		}
+	}
+	return nil, errors.New("chunk not found in container: " + chunkID.ToHexString())
+}
+
+// listChunks describes every chunk in the container as its chunk ID, its type and its path, if it has one
+func (d *UTocData) listChunks() []string {
+	list := []string{}
+	for i := range d.files {
+		f := &d.files[i]
+		list = append(list, strings.TrimSpace(f.chunkID.ToHexString()+" "+chunkTypeName(f.chunkID.Type)+" "+d.gamePath(f)))
+	}
+	return list
+}
+
+// unpackChunk writes the decompressed data of a single chunk to outFile
+func (d *UTocData) unpackChunk(ucasPath string, chunkID FIoChunkID, outFile string) error {
+	f, err := d.findChunk(chunkID)
+	if err != nil {
+		return err
+	}
+	openUcas, err := os.Open(ucasPath)
+	if err != nil {
+		return err
+	}
+	defer openUcas.Close()
+	data, err := d.readFileData(openUcas, f)
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(outFile); dir != "" {
+		os.MkdirAll(dir, 0700)
+	}
+	return os.WriteFile(outFile, *data, 0644)
+}
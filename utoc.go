@@ -67,8 +67,18 @@ func (h *UTocHeader) isEncrypted() bool {
 	return h.ContainerFlags&EncryptedContainerFlag != 0
 }
 
+func (h *UTocHeader) isSigned() bool {
+	return h.ContainerFlags&SignedContainerFlag != 0
+}
+
+// containers without a directory index, such as global.utoc, only have chunks without a path
+func (h *UTocHeader) isIndexed() bool {
+	return h.ContainerFlags&IndexedContainerFlag != 0 && h.DirectoryIndexSize != 0
+}
+
 // ucas file consists of files. For each file, there is an entry with this data.
 // It states where you can find which file in the ucas file.
+// The filepath is empty for chunks that are not in the directory index; those are only known by their chunk ID and type.
 type GameFileMetaData struct {
 	filepath          string
 	chunkID           FIoChunkID
@@ -82,6 +92,37 @@ type UTocData struct {
 	mountPoint         string
 	files              []GameFileMetaData
 	compressionMethods []string
+	compressionBlocks  []FIoStoreTocCompressedBlockEntry // the compression block table; the blocks of a chunk are found by its offset
+	signature          *containerSignature               // nil when the container is not signed
+	aesKey             []byte                            // the key of an encrypted container, chosen by its EncryptionKeyGuid
+	ignoredMountPoint  string                            // a mount point that does not start with MountPoint, of which the paths are ignored
+}
+
+// name returns the path of the file, or the chunk ID for chunks without a path
+func (f *GameFileMetaData) name() string {
+	if f.filepath == "" {
+		return "chunk " + f.chunkID.ToHexString()
+	}
+	return f.filepath
+}
+
+// checkRewritable returns an error for a container of which the paths were ignored, see ignoredMountPoint;
+// writing it again would drop its directory index, and with it the paths of all files
+func (u *UTocData) checkRewritable() error {
+	if u.ignoredMountPoint == "" {
+		return nil
+	}
+	return errors.New("the mount point " + u.ignoredMountPoint + " does not start with " + MountPoint + "; the container can't be written again without losing the paths of its files")
+}
+
+// hasDependencies reports whether the container has a container header ("dependencies"); global.utoc has none
+func (u *UTocData) hasDependencies() bool {
+	for _, f := range u.files {
+		if f.filepath == DepFileName {
+			return true
+		}
+	}
+	return false
 }
 
 type GameFilePathData struct {
@@ -107,37 +148,8 @@ func (u *UTocData) unpackDependencies(ucasPath string) (*[]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	var compressionblockData [][]byte
-	for _, b := range depfile.compressionBlocks {
-		_, err = openUcas.Seek(int64(b.GetOffset()), 0)
-		if err != nil {
-			return nil, err
-		}
-		buf := make([]byte, b.GetCompressedSize())
-		readBytes, err := openUcas.Read(buf)
-		if err != nil {
-			return nil, err
-		}
-		if uint32(readBytes) != b.GetCompressedSize() {
-			return nil, errors.New("could not read the correct size")
-		}
-		compressionblockData = append(compressionblockData, buf)
-	}
-	// all separate blocks collected for file unpacking
-	outputData := []byte{}
-	for i := 0; i < len(compressionblockData); i++ {
-		method := u.compressionMethods[depfile.compressionBlocks[i].CompressionMethod]
-		decomp := getDecompressionFunction(method)
-		if decomp == nil {
-			return nil, errors.New(fmt.Sprintf("decompression method %s not known", method))
-		}
-		newData, err := decomp(&(compressionblockData[i]), depfile.compressionBlocks[i].GetUncompressedSize())
-		if err != nil {
-			return nil, err
-		}
-		outputData = append(outputData, (*newData)...)
-	}
-	return &outputData, nil
+	defer openUcas.Close()
+	return u.readFileData(openUcas, &depfile)
 }
 
 func recursiveDirExplorer(parentPath string, pDir uint32, outputList *[]GameFilePathData,
@@ -163,7 +175,7 @@ func recursiveDirExplorer(parentPath string, pDir uint32, outputList *[]GameFile
 	}
 }
 
-// returned is a slice of filepaths in the correct order.
+// returned is the complete mount point and a slice of filepaths in the correct order.
 // meaning that the indices correspond to their file-index userData field.
 func parseDirectoryIndex(r *bytes.Reader, numberOfChunks int) (mountpoint string, filepaths *[]string) {
 	var size, dirCount, fileCount, stringCount uint32
@@ -173,15 +185,20 @@ func parseDirectoryIndex(r *bytes.Reader, numberOfChunks int) (mountpoint string
 
 	// mount point string
 	binary.Read(r, binary.LittleEndian, &size)
+	if size == 0 || int64(size) > r.Size() {
+		return "", nil
+	}
 	mntPt := make([]byte, size)
 	binary.Read(r, binary.LittleEndian, &mntPt)
-	mountPointName := string(mntPt[:len(mntPt)-1])
-	if !strings.HasPrefix(mountPointName, MountPoint) {
+	if mntPt[len(mntPt)-1] != 0 {
 		return "", nil
 	}
-	mountpoint = strings.TrimPrefix(mountPointName, MountPoint)
+	mountpoint = string(mntPt[:len(mntPt)-1])
 	var dirEntry FIoDirectoryIndexEntry
 	binary.Read(r, binary.LittleEndian, &dirCount)
+	if int64(dirCount)*int64(binary.Size(dirEntry)) > int64(r.Len()) {
+		return "", nil
+	}
 	for i := 0; uint32(i) < dirCount; i++ {
 		binary.Read(r, binary.LittleEndian, &dirEntry)
 		dirs = append(dirs, dirEntry)
@@ -189,6 +206,9 @@ func parseDirectoryIndex(r *bytes.Reader, numberOfChunks int) (mountpoint string
 
 	var fileEntry FIoFileIndexEntry
 	binary.Read(r, binary.LittleEndian, &fileCount)
+	if int64(fileCount)*int64(binary.Size(fileEntry)) > int64(r.Len()) {
+		return "", nil
+	}
 	for i := 0; uint32(i) < fileCount; i++ {
 		binary.Read(r, binary.LittleEndian, &fileEntry)
 		files = append(files, fileEntry)
@@ -197,11 +217,14 @@ func parseDirectoryIndex(r *bytes.Reader, numberOfChunks int) (mountpoint string
 	binary.Read(r, binary.LittleEndian, &stringCount)
 	for i := 0; uint32(i) < stringCount; i++ {
 		binary.Read(r, binary.LittleEndian, &size)
+		if size == 0 || int64(size) > int64(r.Len()) {
+			return "", nil
+		}
 		newString := make([]byte, size)
 		binary.Read(r, binary.LittleEndian, &newString)
 		strTable = append(strTable, string(newString[:len(newString)-1]))
 	}
-	if dirs[0].Name != NoneEntry {
+	if len(dirs) == 0 || dirs[0].Name != NoneEntry {
 		return "", nil
 	}
 
@@ -212,6 +235,9 @@ func parseDirectoryIndex(r *bytes.Reader, numberOfChunks int) (mountpoint string
 	// order the filepaths according to their userdata
 	orderedPaths := make([]string, numberOfChunks)
 	for _, v := range gamefilePaths {
+		if int(v.userData) >= numberOfChunks {
+			return "", nil
+		}
 		orderedPaths[v.userData] = v.fpath
 	}
 
@@ -243,16 +269,12 @@ func parseUtocHeader(r *bytes.Reader) (hdr UTocHeader, err error) {
 		return hdr, errors.New("compressed block entry size was incorrect")
 	}
 
-	if hdr.ContainerFlags != 0 && uint8(hdr.ContainerFlags)&SignedContainerFlag != 0 {
-		// the reference project may contain flags here, but no idea what it should do...
-		return hdr, errors.New("the unreal engine dictates that this is an error. No idea why (yet)... Sorry!")
-	}
-
 	return hdr, nil
 }
 
 // the UTocData can be used to extract all information from the ucas files
-func parseUtocFile(utocFile string, aesKey []byte) (*UTocData, error) {
+// The key of an encrypted container is taken from the keyring, which may be nil for unencrypted containers.
+func parseUtocFile(utocFile string, keys *Keyring) (*UTocData, error) {
 	var udata UTocData
 	b, err := ioutil.ReadFile(utocFile)
 	if err != nil {
@@ -265,8 +287,9 @@ func parseUtocFile(utocFile string, aesKey []byte) (*UTocData, error) {
 		return nil, err
 	}
 	if udata.hdr.isEncrypted() {
-		if len(aesKey) == 0 {
-			return &udata, errors.New("encrypted file, but no AES key was provided! Please pass the aes key as a string in hexadecimal format")
+		udata.aesKey, err = keys.keyFor(udata.hdr.EncryptionKeyGuid)
+		if err != nil {
+			return &udata, err
 		}
 	}
 
@@ -309,6 +332,7 @@ func parseUtocFile(utocFile string, aesKey []byte) (*UTocData, error) {
 		binary.Read(r, binary.LittleEndian, &cBlock)
 		compressionBlocks = append(compressionBlocks, cBlock)
 	}
+	udata.compressionBlocks = compressionBlocks
 	// read compression methods
 	udata.compressionMethods = append(udata.compressionMethods, "None")
 
@@ -318,25 +342,49 @@ func parseUtocFile(utocFile string, aesKey []byte) (*UTocData, error) {
 		udata.compressionMethods = append(udata.compressionMethods, string(bytes.Trim([]byte(method[:]), "\x00")))
 	}
 
-	// read directory index, but only if the containerFlags states that is present. TODO?
-	dirIndexBuffer := make([]byte, udata.hdr.DirectoryIndexSize)
-	binary.Read(r, binary.LittleEndian, &dirIndexBuffer) // normal reader is advanced here as well
-
-	if udata.hdr.isEncrypted() {
-		plaintext, err := decryptAES(&dirIndexBuffer, aesKey)
+	// signed containers have the signatures and the hashes of all compression blocks here
+	if udata.hdr.isSigned() {
+		udata.signature, err = parseSignature(r, b[:binary.Size(udata.hdr)], udata.hdr.CompressedBlockEntryCount)
 		if err != nil {
 			return &udata, err
 		}
-		dirIndexBuffer = *plaintext
 	}
-	dirReader := bytes.NewReader(dirIndexBuffer)
 
-	mntPt, fpaths := parseDirectoryIndex(dirReader, len(chunkIDs))
-	udata.mountPoint = mntPt
-	if fpaths == nil {
-		return &udata, errors.New("something went wrong parsing the directory index!")
+	// read directory index, but only if the containerFlags states that is present.
+	dirIndexBuffer := make([]byte, udata.hdr.DirectoryIndexSize)
+	binary.Read(r, binary.LittleEndian, &dirIndexBuffer) // normal reader is advanced here as well
+
+	// without a directory index, all chunks are known by their chunk ID and type only
+	filepaths = make([]string, len(chunkIDs))
+	if udata.hdr.isIndexed() {
+		if udata.hdr.isEncrypted() {
+			if len(dirIndexBuffer)%16 != 0 {
+				return &udata, errors.New("the encrypted directory index is not a multiple of 16 bytes")
+			}
+			plaintext, err := decryptAES(&dirIndexBuffer, udata.aesKey)
+			if err != nil {
+				return &udata, err
+			}
+			dirIndexBuffer = *plaintext
+		}
+		dirReader := bytes.NewReader(dirIndexBuffer)
+
+		mntPt, fpaths := parseDirectoryIndex(dirReader, len(chunkIDs))
+		if fpaths == nil && udata.hdr.isEncrypted() {
+			// a wrong key decrypts to garbage, which is noticed here before anything else is read
+			return &udata, errors.New("the AES key for the encryption key GUID " + udata.hdr.EncryptionKeyGuid.String() + " is wrong; the directory index could not be decrypted")
+		}
+		if fpaths == nil {
+			return &udata, errors.New("something went wrong parsing the directory index!")
+		}
+		if strings.HasPrefix(mntPt, MountPoint) {
+			udata.mountPoint = strings.TrimPrefix(mntPt, MountPoint)
+			filepaths = *fpaths
+		} else {
+			// the files can't be placed below the root of the game, so they are treated like chunks without a path
+			udata.ignoredMountPoint = mntPt
+		}
 	}
-	filepaths = *fpaths
 
 	// read file chunk metas
 	var meta FIoStoreTocEntryMeta
@@ -344,7 +392,7 @@ func parseUtocFile(utocFile string, aesKey []byte) (*UTocData, error) {
 		binary.Read(r, binary.LittleEndian, &meta)
 		metas = append(metas, meta)
 	}
-	//temporary dependency "file" because it isn't always the last chunk
+	// dependency "file" because it isn't always the last chunk; containers such as global.utoc don't have one
 	var foundDeps bool
 	var path string
 	// aggregate file data
@@ -356,12 +404,12 @@ func parseUtocFile(utocFile string, aesKey []byte) (*UTocData, error) {
 		if v == "" {
 			// check for "dependencies" chunk via type instead of assuming it's last.
 			// in the sample im running this on, the chunkID matches with the one in the header.
-			if chunkIDs[i].Type != 10 && uint64(udata.hdr.ContainerID) != chunkIDs[i].ID {
-				// if the name is empty, the type is 10, and the chunkID doesnt match, then it's not the dependencies
-				continue
+			// other chunks without a path are kept with an empty path.
+			path = ""
+			if !foundDeps && chunkIDs[i].Type == 10 {
+				foundDeps = true
+				path = DepFileName
 			}
-			foundDeps = true
-			path = DepFileName
 		} else {
 			path = v
 		}
@@ -373,11 +421,5 @@ func parseUtocFile(utocFile string, aesKey []byte) (*UTocData, error) {
 			metadata:          metas[i],
 		})
 	}
-	if !foundDeps {
-		return &udata, errors.New("couldn't find dependencies")
-	}
-	// the final file in the list will have filepath "dependencies"
-	// //manually stick this on at the end for compatibility?
-	// udata.files = append(udata.files, deps)
 	return &udata, nil
 }
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The containerWriter writes a new .ucas file one compression block at a time.
+// Chunks can either be copied from another container without recompressing them,
+// or they can be written from their uncompressed data.
+// Meanwhile, all metadata that is needed for the .utoc file is recorded.
+type containerWriter struct {
+	ucas               *os.File
+	ucasOffset         uint64 // offset of the next compressed block in the .ucas file
+	blockSize          uint32
+	blockAlignment     uint32         // alignment of the compressed blocks in the .ucas file
+	mountPoint         string         // the paths of the files are relative to the mount point
+	containerID        FIoContainerID // 0 for the ID of the container header
+	blockCount         uint64
+	compressionMethods []string // index 0 is always "None"
+	files              []GameFileMetaData
+
+	// state of the chunk that is currently written from uncompressed data
+	current    *GameFileMetaData
+	codec      Codec
+	policies   map[string]*CompressionPolicy // the parsed compression policies, so the codecs are only created once
+	pending    []byte                        // data that does not fill a complete block yet
+	compressed []byte                        // buffer for the compressed block
+	hasher     hash.Hash
+}
+
+func newContainerWriter(outFilename string, blockSize uint32) (*containerWriter, error) {
+	if err := checkBlockSize(blockSize); err != nil {
+		return nil, err
+	}
+	directory := filepath.Dir(outFilename)
+	os.MkdirAll(directory, 0700)
+	f, err := os.Create(outFilename + ".ucas")
+	if err != nil {
+		return nil, err
+	}
+	return &containerWriter{
+		ucas:               f,
+		blockSize:          blockSize,
+		blockAlignment:     0x10,
+		mountPoint:         MountPoint,
+		compressionMethods: []string{"None"},
+		policies:           map[string]*CompressionPolicy{},
+	}, nil
+}
+
+// returns the index of the compression method, the method is added if it's not yet known
+func (w *containerWriter) methodIndex(method string) uint8 {
+	for i, m := range w.compressionMethods {
+		if normalizeCompressionMethod(m) == normalizeCompressionMethod(method) {
+			return uint8(i)
+		}
+	}
+	w.compressionMethods = append(w.compressionMethods, method)
+	return uint8(len(w.compressionMethods) - 1)
+}
+
+// every chunk starts at a new compression block, which is how the blocks of a chunk are found.
+func (w *containerWriter) nextChunkOffset() uint64 {
+	return w.blockCount * uint64(w.blockSize)
+}
+
+// writes one (compressed) block to the .ucas file, aligned to the block alignment
+func (w *containerWriter) writeBlock(data []byte, uncompressedSize uint32, method string) (FIoStoreTocCompressedBlockEntry, error) {
+	var block FIoStoreTocCompressedBlockEntry
+	block.CompressionMethod = w.methodIndex(method)
+	if err := block.SetOffset(w.ucasOffset); err != nil {
+		return block, err
+	}
+	if err := block.SetUncompressedSize(uncompressedSize); err != nil {
+		return block, err
+	}
+	if err := block.SetCompressedSize(uint32(len(data))); err != nil {
+		return block, err
+	}
+	alignment := int(w.blockAlignment)
+	padding := make([]byte, (alignment-(len(data)%alignment))%alignment)
+	if _, err := w.ucas.Write(data); err != nil {
+		return block, err
+	}
+	if _, err := w.ucas.Write(padding); err != nil {
+		return block, err
+	}
+	w.ucasOffset += uint64(len(data) + len(padding))
+	w.blockCount++
+	return block, nil
+}
+
+// copyChunk copies the compressed blocks of a chunk from another container, without recompressing them.
+func (w *containerWriter) copyChunk(src *UTocData, srcUcas *os.File, f *GameFileMetaData) error {
+	if src.hdr.CompressionBlockSize != w.blockSize {
+		return errors.New(fmt.Sprintf("compression block size %#x differs from %#x; the blocks can't be copied", src.hdr.CompressionBlockSize, w.blockSize))
+	}
+	blockData, err := readCompressionBlocks(srcUcas, f.compressionBlocks)
+	if err != nil {
+		return err
+	}
+	newFile := GameFileMetaData{
+		filepath: f.filepath,
+		chunkID:  f.chunkID,
+		metadata: f.metadata,
+	}
+	if newFile.filepath == DepFileName {
+		newFile.filepath = ""
+	}
+	if err := newFile.offlen.SetOffset(w.nextChunkOffset()); err != nil {
+		return err
+	}
+	newFile.offlen.SetLength(f.offlen.GetLength())
+	for i, b := range f.compressionBlocks {
+		block, err := w.writeBlock(blockData[i], b.GetUncompressedSize(), src.compressionMethods[b.CompressionMethod])
+		if err != nil {
+			return err
+		}
+		newFile.compressionBlocks = append(newFile.compressionBlocks, block)
+	}
+	w.files = append(w.files, newFile)
+	return nil
+}
+
+// beginChunk starts a new chunk, of which the uncompressed data is passed to write.
+// The compression is a compression policy, see ParseCompressionPolicy.
+// The dependencies "file" has no path in the directory index.
+func (w *containerWriter) beginChunk(fpath string, chunkID FIoChunkID, compression string) error {
+	policy, ok := w.policies[compression]
+	if !ok {
+		var err error
+		policy, err = ParseCompressionPolicy(compression)
+		if err != nil {
+			return err
+		}
+		w.policies[compression] = policy
+	}
+	if fpath == DepFileName {
+		fpath = ""
+	}
+	w.current = &GameFileMetaData{
+		filepath: fpath,
+		chunkID:  chunkID,
+	}
+	if err := w.current.offlen.SetOffset(w.nextChunkOffset()); err != nil {
+		return err
+	}
+	// rules match the path below the root of the game, like when packing
+	w.codec, _ = policy.codecFor("/"+relativeMountPoint(w.mountPoint)+strings.TrimPrefix(fpath, "/"), chunkID)
+	w.pending = w.pending[:0]
+	w.hasher = sha1.New()
+	return nil
+}
+
+// compresses and writes one block of the current chunk
+func (w *containerWriter) flushBlock(chunk []byte) error {
+	compressedChunk, method, err := compressBlock(w.codec, w.compressed[:0], chunk)
+	if err != nil {
+		return err
+	}
+	w.compressed = compressedChunk
+	block, err := w.writeBlock(compressedChunk, uint32(len(chunk)), method)
+	if err != nil {
+		return err
+	}
+	w.current.compressionBlocks = append(w.current.compressionBlocks, block)
+	return nil
+}
+
+// write adds uncompressed data to the current chunk; every complete block is written immediately.
+func (w *containerWriter) write(data []byte) error {
+	w.hasher.Write(data)
+	if err := w.current.offlen.SetLength(w.current.offlen.GetLength() + uint64(len(data))); err != nil {
+		return err
+	}
+	for len(data) != 0 {
+		n := int(w.blockSize) - len(w.pending)
+		if n > len(data) {
+			n = len(data)
+		}
+		if len(w.pending) == 0 && n == int(w.blockSize) {
+			// a complete block, no need to copy it first
+			if err := w.flushBlock(data[:n]); err != nil {
+				return err
+			}
+		} else {
+			w.pending = append(w.pending, data[:n]...)
+			if len(w.pending) == int(w.blockSize) {
+				if err := w.flushBlock(w.pending); err != nil {
+					return err
+				}
+				w.pending = w.pending[:0]
+			}
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// endChunk writes the remaining data of the current chunk.
+// If no metadata is passed, the hash is calculated from the data that was written.
+func (w *containerWriter) endChunk(metadata *FIoStoreTocEntryMeta) error {
+	if len(w.pending) != 0 {
+		if err := w.flushBlock(w.pending); err != nil {
+			return err
+		}
+		w.pending = w.pending[:0]
+	}
+	if metadata != nil {
+		w.current.metadata = *metadata
+	} else {
+		copy(w.current.metadata.ChunkHash.Hash[:], w.hasher.Sum(nil))
+		w.current.metadata.Flags = 1
+	}
+	w.files = append(w.files, *w.current)
+	w.current = nil
+	return nil
+}
+
+// writeChunk compresses the data of a new chunk and writes it block by block.
+func (w *containerWriter) writeChunk(fpath string, chunkID FIoChunkID, data []byte, compression string) error {
+	err := w.beginChunk(fpath, chunkID, compression)
+	if err != nil {
+		return err
+	}
+	err = w.write(data)
+	if err != nil {
+		return err
+	}
+	return w.endChunk(nil)
+}
+
+// writeDependencies writes the dependencies (container header) as a chunk.
+// The ThisPackageID of the dependencies is used as container ID.
+func (w *containerWriter) writeDependencies(deps *Dependencies) error {
+	depChunkID := FIoChunkID{ID: deps.ThisPackageID, Type: 10}
+	return w.writeChunk("", depChunkID, *deps.Deparse(), "None")
+}
+
+// finish closes the .ucas file and creates the .utoc file.
+func (w *containerWriter) finish(outFilename string, aes []byte) error {
+	err := w.ucas.Close()
+	if err != nil {
+		return err
+	}
+	utocBytes, err := constructUtocFile(&w.files, w.compressionMethods, w.blockSize, w.mountPoint, 0, w.containerID, nil, FGuid{}, aes)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outFilename+".utoc", *utocBytes, os.ModePerm)
+}
+
+// close and remove the .ucas file without finishing, in case something went wrong
+func (w *containerWriter) abort() {
+	w.ucas.Close()
+	os.Remove(w.ucas.Name())
+}